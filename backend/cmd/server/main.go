@@ -4,15 +4,24 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/Mond1c/gitea-classroom/config"
 	"github.com/Mond1c/gitea-classroom/frontend"
-	"github.com/Mond1c/gitea-classroom/internal/cache"
+	"github.com/Mond1c/gitea-classroom/internal/bot"
 	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/deadline"
+	"github.com/Mond1c/gitea-classroom/internal/grading"
 	"github.com/Mond1c/gitea-classroom/internal/handlers"
+	"github.com/Mond1c/gitea-classroom/internal/hooks"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
 	"github.com/Mond1c/gitea-classroom/internal/logger"
 	mw "github.com/Mond1c/gitea-classroom/internal/middleware"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/pubsub"
+	"github.com/Mond1c/gitea-classroom/internal/review"
 	"github.com/Mond1c/gitea-classroom/internal/services"
+	"github.com/Mond1c/gitea-classroom/internal/sinks"
 	"github.com/Mond1c/gitea-classroom/internal/workers"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -49,14 +58,57 @@ func main() {
 		}
 	}
 
-	// Initialize review cache and worker
-	reviewCache := cache.NewReviewCache()
-	reviewWorker := workers.NewReviewWorker(reviewCache, sheetsService)
+	// Initialize review sinks, courses choose among the ones configured here
+	availableSinks := []sinks.ReviewSink{sinks.NewDatabaseSink()}
+	if sheetsService != nil {
+		availableSinks = append(availableSinks, sinks.NewSheetsSink(sheetsService))
+	}
+	if cfg.ReviewSinkFile != "" {
+		fileSink, err := sinks.NewFileSink(cfg.ReviewSinkFile)
+		if err != nil {
+			log.Printf("Warning: Failed to initialize file review sink: %v", err)
+		} else {
+			availableSinks = append(availableSinks, fileSink)
+		}
+	}
+	if cfg.ReviewSinkWebhookURL != "" {
+		availableSinks = append(availableSinks, sinks.NewWebhookSink(cfg.ReviewSinkWebhookURL, cfg.ReviewSinkWebhookSecret))
+	}
+	reviewSinks := sinks.NewRegistry(cfg.ReviewSinks, availableSinks...)
+
+	// Initialize review worker, it resumes pending requests left by a previous run
+	reviewAssigner := review.NewAssigner(cfg)
+	repoLock := review.NewRepoLock(cfg)
+	reviewEvents := pubsub.NewBroker()
+	reviewMachine := review.NewMachine(cfg, reviewSinks, reviewAssigner, repoLock, reviewEvents)
+
+	botMessages, err := bot.LoadMessages(cfg.BotMessagesFile)
+	if err != nil {
+		log.Fatal("Failed to load bot messages:", err)
+	}
+	reviewBot := bot.New(cfg, botMessages)
+	reviewWorker := workers.NewReviewWorker(reviewMachine, time.Duration(cfg.ReviewPendingMinutes)*time.Minute)
 	reviewWorker.Start()
 	defer reviewWorker.Stop()
 
+	lockWorker := workers.NewLockWorker(repoLock, 10*time.Minute)
+	lockWorker.Start()
+	defer lockWorker.Stop()
+
+	slaWorker := workers.NewSLAWorker(cfg, reviewBot)
+	slaWorker.Start()
+	defer slaWorker.Stop()
+
+	hookManager := hooks.NewManager(cfg)
+	hookWorker := workers.NewHookWorker(hookManager, 6*time.Hour)
+	hookWorker.Start()
+	defer hookWorker.Stop()
+
+	jobQueue := jobs.NewQueue(cfg)
+
 	e := echo.New()
 
+	e.Pre(mw.RedactTokens())
 	e.Use(middleware.RequestLogger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
@@ -66,13 +118,47 @@ func main() {
 	}))
 
 	authHandler := handlers.NewAuthHandler(cfg)
-	courseHandler := handlers.NewCourseHandler(cfg)
+	courseHandler := handlers.NewCourseHandler(cfg, hookManager)
 	assignmentHandler := handlers.NewAssignmentHandler(cfg)
+	rubricHandler := handlers.NewRubricHandler(cfg)
 	studentHandler := handlers.NewStudentHandler(cfg)
-	submissionHandler := handlers.NewSubmissionHandler(cfg)
-	reviewHandler := handlers.NewReviewHandler(cfg, reviewCache, sheetsService)
-	webhookHandler := handlers.NewWebhookHandler(cfg, reviewCache, sheetsService)
+	submissionHandler := handlers.NewSubmissionHandler(cfg, hookManager, jobQueue)
+	reviewHandler := handlers.NewReviewHandler(cfg, reviewMachine, reviewEvents)
+	webhookHandler := handlers.NewWebhookHandler(cfg, reviewMachine, reviewAssigner, reviewBot, jobQueue)
 	inviteHandler := handlers.NewInviteHandler(cfg)
+	jobHandler := handlers.NewJobHandler(jobQueue)
+
+	// Job handlers are registered before the workers start claiming jobs
+	jobQueue.Register(handlers.JobKindWebhook, webhookHandler.ProcessDeliveryJob)
+	jobQueue.Register(review.JobKindBackfillIDs, func(*models.Job) error {
+		return review.BackfillIDs(cfg)
+	})
+	jobQueue.Register(grading.JobKind, grading.NewRunner(cfg).ProcessJob)
+	jobQueue.Register(deadline.JobKind, deadline.NewSnapshotter(cfg, jobQueue).ProcessJob)
+
+	// Grading runs take minutes, webhooks must not wait behind them
+	gradingPool := jobQueue.Pool(grading.JobKind)
+
+	jobWorker := workers.NewJobWorker(jobQueue.Shared(), cfg.JobWorkers, 5*time.Second)
+	jobWorker.Start()
+	defer jobWorker.Stop()
+
+	gradingWorker := workers.NewJobWorker(gradingPool, cfg.GradingWorkers, 5*time.Second)
+	gradingWorker.Start()
+	defer gradingWorker.Stop()
+
+	deadlineWorker := workers.NewDeadlineWorker(jobQueue, time.Minute)
+	deadlineWorker.Start()
+	defer deadlineWorker.Stop()
+
+	// Submissions created before Gitea IDs were recorded
+	if missing, err := review.MissingIDs(); err != nil {
+		log.Printf("Warning: failed to count submissions without Gitea IDs: %v", err)
+	} else if missing > 0 {
+		if _, err := jobQueue.Enqueue(review.JobKindBackfillIDs, struct{}{}); err != nil {
+			log.Printf("Warning: failed to queue Gitea ID backfill: %v", err)
+		}
+	}
 
 	e.GET("/api/health", func(c echo.Context) error {
 		return c.JSON(200, map[string]string{"status": "ok"})
@@ -86,6 +172,9 @@ func main() {
 	e.GET("/api/join/:code", inviteHandler.GetAvailableStudents)
 	e.POST("/api/join/:code/register", inviteHandler.RegisterStudent)
 
+	// EventSource cannot send headers, the stream also takes a stream token
+	e.GET("/api/submissions/:id/review/events", reviewHandler.StreamReviewEvents, mw.StreamAuthMiddleware(cfg.JWTSecret))
+
 	api := e.Group("/api")
 	api.Use(mw.AuthMiddleware(cfg.JWTSecret))
 
@@ -95,13 +184,17 @@ func main() {
 	api.GET("/courses", courseHandler.List)
 	api.GET("/courses/enrolled", courseHandler.ListEnrolled)
 	api.GET("/courses/:slug", courseHandler.Get)
+	api.PUT("/courses/:slug", courseHandler.Update)
 	api.POST("/courses/:slug/regenerate-invite", courseHandler.RegenerateInviteCode)
+	api.POST("/courses/:slug/webhooks/repair", courseHandler.RepairWebhooks)
 
 	api.GET("/courses/:slug/assignments", assignmentHandler.List)
 	api.POST("/courses/:slug/assignments", assignmentHandler.Create)
 	api.GET("/assignments/:id", assignmentHandler.Get)
 	api.PUT("/assignments/:id", assignmentHandler.Update)
 	api.DELETE("/assignments/:id", assignmentHandler.Delete)
+	api.GET("/assignments/:id/rubric", rubricHandler.Get)
+	api.PUT("/assignments/:id/rubric", rubricHandler.Update)
 
 	api.GET("/courses/:slug/students", studentHandler.List)
 	api.POST("/courses/:slug/enroll", studentHandler.Enroll)
@@ -116,12 +209,29 @@ func main() {
 	api.GET("/assignments/:id/submissions", submissionHandler.List)
 	api.GET("/submissions/:submissionId", submissionHandler.Get)
 	api.POST("/submissions/:submissionId/grade", submissionHandler.Grade)
+	api.GET("/submissions/:submissionId/commits", submissionHandler.ListCommits)
+	api.GET("/submissions/:submissionId/checks", submissionHandler.ListChecks)
+	api.POST("/submissions/:submissionId/autograde", submissionHandler.Autograde)
+	api.GET("/submissions/:submissionId/grading-runs", submissionHandler.ListGradingRuns)
 
 	// Review endpoints
 	api.POST("/submissions/:id/review/request", reviewHandler.RequestReview)
 	api.DELETE("/reviews/:id/cancel", reviewHandler.CancelReview)
 	api.GET("/submissions/:id/review/status", reviewHandler.GetReviewStatus)
+	api.GET("/submissions/:id/reviews", reviewHandler.ListSubmissionReviews)
+	api.POST("/submissions/:id/review/events/token", reviewHandler.StreamToken)
 	api.POST("/reviews/:id/mark-reviewed", reviewHandler.MarkReviewed)
+	api.GET("/courses/:slug/reviews", reviewHandler.ListCourseReviews)
+	api.GET("/courses/:slug/reviews/overdue", reviewHandler.ListOverdueReviews)
+	api.GET("/courses/:slug/reviews/stats", reviewHandler.ReviewStats)
+
+	// Webhook delivery log (admin only)
+	api.GET("/admin/webhooks/deliveries", webhookHandler.ListWebhookDeliveries)
+	api.GET("/admin/webhooks/deliveries/:id", webhookHandler.GetWebhookDelivery)
+	api.POST("/admin/webhooks/deliveries/:id/replay", webhookHandler.ReplayWebhookDelivery)
+	api.GET("/admin/jobs", jobHandler.List)
+	api.POST("/admin/jobs/:id/retry", jobHandler.Retry)
+	api.POST("/admin/submissions/backfill-ids", jobHandler.BackfillSubmissionIDs)
 
 	// Serve embedded frontend (SPA)
 	distFS, err := fs.Sub(frontend.DistFS, "dist")
@@ -24,6 +24,38 @@ type Config struct {
 	GiteaWebhookSecret   string
 	GoogleCredentials    string
 	GoogleSheetID        string
+
+	// Review sinks
+	ReviewSinks             []string
+	ReviewSinkFile          string
+	ReviewSinkWebhookURL    string
+	ReviewSinkWebhookSecret string
+
+	// Default reviewer assignment strategy: none, round_robin, load_balanced
+	ReviewerAssignment string
+
+	// Default repository lock strategy: permission, branch_protection
+	RepoLockStrategy string
+
+	// Default number of days a submitted review may wait, 0 disables the SLA
+	ReviewSLADays int
+
+	// JSON file overriding the bot's Feedback PR reply templates
+	BotMessagesFile string
+
+	// Background job queue. Grading runs have workers of their own.
+	JobWorkers     int
+	JobMaxAttempts int
+	GradingWorkers int
+
+	// Directory autograding workspaces are created in, empty means the
+	// system temp directory
+	GradingWorkDir string
+
+	// Container runtime graders run in: docker or podman. Autograding is
+	// refused without one. GradingImage is the image they run in.
+	GradingSandbox string
+	GradingImage   string
 }
 
 func Load() (*Config, error) {
@@ -43,6 +75,28 @@ func Load() (*Config, error) {
 		}
 	}
 
+	reviewSLADays := parseInt(getEnv("REVIEW_SLA_DAYS", "7"), 7)
+
+	jobWorkers := parseInt(getEnv("JOB_WORKERS", "4"), 4)
+	if jobWorkers < 1 {
+		jobWorkers = 1
+	}
+	gradingWorkers := parseInt(getEnv("GRADING_WORKERS", "2"), 2)
+	if gradingWorkers < 1 {
+		gradingWorkers = 1
+	}
+	jobMaxAttempts := parseInt(getEnv("JOB_MAX_ATTEMPTS", "8"), 8)
+	if jobMaxAttempts < 1 {
+		jobMaxAttempts = 1
+	}
+
+	reviewSinks := []string{}
+	for _, s := range strings.Split(getEnv("REVIEW_SINKS", "sheets,database"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			reviewSinks = append(reviewSinks, s)
+		}
+	}
+
 	return &Config{
 		Port:                 getEnv("PORT", "8080"),
 		DatabaseURL:          getEnv("DATABASE_URL", ""),
@@ -59,6 +113,25 @@ func Load() (*Config, error) {
 		GiteaWebhookSecret:   getEnv("GITEA_WEBHOOK_SECRET", ""),
 		GoogleCredentials:    getEnv("GOOGLE_CREDENTIALS_FILE", ""),
 		GoogleSheetID:        getEnv("GOOGLE_SHEET_ID", ""),
+
+		ReviewSinks:             reviewSinks,
+		ReviewSinkFile:          getEnv("REVIEW_SINK_FILE", ""),
+		ReviewSinkWebhookURL:    getEnv("REVIEW_SINK_WEBHOOK_URL", ""),
+		ReviewSinkWebhookSecret: getEnv("REVIEW_SINK_WEBHOOK_SECRET", ""),
+
+		ReviewerAssignment: getEnv("REVIEWER_ASSIGNMENT", "none"),
+		RepoLockStrategy:   getEnv("REPO_LOCK_STRATEGY", "permission"),
+		ReviewSLADays:      reviewSLADays,
+
+		BotMessagesFile: getEnv("BOT_MESSAGES_FILE", ""),
+
+		JobWorkers:     jobWorkers,
+		JobMaxAttempts: jobMaxAttempts,
+		GradingWorkers: gradingWorkers,
+
+		GradingWorkDir: getEnv("GRADING_WORK_DIR", ""),
+		GradingSandbox: getEnv("GRADING_SANDBOX", ""),
+		GradingImage:   getEnv("GRADING_IMAGE", ""),
 	}, nil
 }
 
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+)
+
+// Bot posts LMS replies as comments on Gitea pull requests using the admin
+// token.
+type Bot struct {
+	cfg      *config.Config
+	messages *Messages
+
+	mu       sync.Mutex
+	username string
+}
+
+func New(cfg *config.Config, messages *Messages) *Bot {
+	return &Bot{cfg: cfg, messages: messages}
+}
+
+// Post renders the message for key and comments it on the given issue or
+// pull request. Outcomes without a message, or a missing admin token, are
+// silently skipped.
+func (b *Bot) Post(owner, repo string, index int64, key string, data map[string]interface{}) error {
+	if b == nil || b.cfg.GiteaAdminToken == "" {
+		return nil
+	}
+
+	body, ok, err := b.messages.Render(key, data)
+	if err != nil || !ok {
+		return err
+	}
+
+	giteaService, err := services.NewGiteaService(b.cfg.GiteaURL, b.cfg.GiteaAdminToken)
+	if err != nil {
+		return err
+	}
+
+	_, err = giteaService.CreateIssueComment(owner, repo, index, body)
+	return err
+}
+
+// IsSelf reports whether username is the account the bot posts as, so its
+// own replies are not read as commands.
+func (b *Bot) IsSelf(username string) bool {
+	if b == nil || b.cfg.GiteaAdminToken == "" {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.username == "" {
+		giteaService, err := services.NewGiteaService(b.cfg.GiteaURL, b.cfg.GiteaAdminToken)
+		if err == nil {
+			var user *gitea.User
+			if user, err = giteaService.GetUser(); err == nil {
+				b.username = user.UserName
+			}
+		}
+		if err != nil {
+			log.Printf("Warning: failed to look up the bot account: %v", err)
+			return false
+		}
+	}
+	return strings.EqualFold(b.username, username)
+}
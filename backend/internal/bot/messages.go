@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// DefaultMessages are the reply templates keyed by command outcome. Templates
+// use text/template syntax and see the command result data, plus "user" and
+// "command".
+var DefaultMessages = map[string]string{
+	"submission_not_found":         "@{{.user}} this repository is not linked to any submission, `{{.command}}` was ignored.",
+	"not_submission_owner":         "@{{.user}} only the owner of this submission can use `{{.command}}`.",
+	"review_already_active":        "@{{.user}} a review request is already active for this submission.",
+	"review_requested":             "@{{.user}} review requested. The repository is read-only until the review is done. You can cancel with `/unreview` until {{.cancel_deadline.Format \"2006-01-02 15:04 MST\"}}.",
+	"no_pending_review":            "@{{.user}} there is no pending review request to cancel.",
+	"review_already_submitted":     "@{{.user}} the review request has already been sent to instructors and can no longer be cancelled.",
+	"review_cancelled":             "@{{.user}} review request cancelled, write access restored.",
+	"review_submitted_immediately": "@{{.user}} review request sent to instructors. The repository is read-only until the review is done.",
+	"commenter_not_found":          "@{{.user}} you are not registered in the LMS, `{{.command}}` was ignored.",
+	"forbidden":                    "@{{.user}} only course instructors can use `{{.command}}`.",
+	"no_review_request":            "@{{.user}} there is no review request to cancel.",
+	"force_cancelled":              "Review request cancelled by @{{.user}}, write access restored.",
+	"invalid_grade":                "@{{.user}} could not grade: {{.message}}.",
+	"graded":                       "Graded by @{{.user}}: {{.score}}/{{.max_points}}.",
+	"no_active_review":             "@{{.user}} there is no active review request to claim.",
+	"claimed":                      "@{{.reviewer}} is reviewing this submission.",
+	"review_overdue":               "{{.mentions}} the review request of @{{.student}} has been waiting {{.waiting_days}} days, over the {{.sla_days}} day review SLA.",
+	"not_team_member":              "@{{.user}} only members of this repository can use `{{.command}}`.",
+	"admin_only":                   "@{{.user}} only LMS admins can use `{{.command}}`.",
+	"command_disabled":             "@{{.user}} `{{.command}}` is disabled in this course.",
+	"help":                         "@{{.user}} available commands:\n\n{{.commands}}",
+}
+
+// Messages holds parsed reply templates.
+type Messages struct {
+	templates map[string]*template.Template
+}
+
+// NewMessages parses the default templates with overrides applied on top.
+// An empty override disables the reply for that outcome.
+func NewMessages(overrides map[string]string) (*Messages, error) {
+	texts := make(map[string]string, len(DefaultMessages))
+	for key, text := range DefaultMessages {
+		texts[key] = text
+	}
+	for key, text := range overrides {
+		texts[key] = text
+	}
+
+	m := &Messages{templates: make(map[string]*template.Template, len(texts))}
+	for key, text := range texts {
+		if text == "" {
+			continue
+		}
+		tmpl, err := template.New(key).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("bot message %q: %w", key, err)
+		}
+		m.templates[key] = tmpl
+	}
+	return m, nil
+}
+
+// LoadMessages reads template overrides from a JSON object file mapping
+// outcome keys to templates. An empty path yields the defaults.
+func LoadMessages(path string) (*Messages, error) {
+	if path == "" {
+		return NewMessages(nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return NewMessages(overrides)
+}
+
+// Render executes the template for key. It reports false when there is no
+// message for that outcome.
+func (m *Messages) Render(key string, data map[string]interface{}) (string, bool, error) {
+	tmpl, ok := m.templates[key]
+	if !ok {
+		return "", false, nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", false, err
+	}
+	return buf.String(), true, nil
+}
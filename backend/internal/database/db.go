@@ -3,6 +3,7 @@ package database
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/Mond1c/gitea-classroom/internal/models"
 	"gorm.io/driver/postgres"
@@ -23,13 +24,44 @@ func Connect(databaseURL string) error {
 }
 
 func Migrate() error {
-	return DB.AutoMigrate(
+	// Deadlines are snapshotted from the time the column is added on, those
+	// already past would record today's heads instead
+	addsDeadlineProcessed := !DB.Migrator().HasColumn(&models.Assignment{}, "deadline_processed_at")
+
+	err := DB.AutoMigrate(
 		&models.User{},
 		&models.Course{},
 		&models.Assignment{},
 		&models.Student{},
 		&models.Submission{},
+		&models.Rubric{},
+		&models.RubricCriterion{},
+		&models.RubricDescriptor{},
+		&models.CriterionScore{},
+		&models.Commit{},
+		&models.CommitCheck{},
 		&models.ReviewRequest{},
+		&models.ReviewSinkEntry{},
+		&models.ReviewEvent{},
+		&models.WebhookDelivery{},
+		&models.Job{},
+		&models.GradingRun{},
+		&models.GradingTestResult{},
 		&models.StudentInvite{},
 	)
+	if err != nil {
+		return err
+	}
+
+	if addsDeadlineProcessed {
+		now := time.Now()
+		result := DB.Model(&models.Assignment{}).
+			Where("deadline > ? AND deadline <= ?", time.Time{}, now).
+			Update("deadline_processed_at", now)
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark past deadlines as processed: %w", result.Error)
+		}
+		log.Printf("Marked %d past deadlines as processed", result.RowsAffected)
+	}
+	return nil
 }
@@ -0,0 +1,179 @@
+package deadline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/grading"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+	"gorm.io/gorm"
+)
+
+// JobKind is the job snapshotting the submissions of an assignment once its
+// deadline passed
+const JobKind = "deadline_snapshot"
+
+// Tag marks the deadline commit in submission repositories. It is protected
+// from students, the stored DeadlineSHA stays the record of the deadline.
+const Tag = "deadline"
+
+const (
+	// branch is the branch whose head is recorded at the deadline
+	branch = "main"
+
+	// maxLateCommits bounds the walk back over commits pushed late
+	maxLateCommits = 200
+)
+
+type job struct {
+	AssignmentID uint `json:"assignment_id"`
+}
+
+// Enqueue queues the deadline snapshot of an assignment.
+func Enqueue(queue *jobs.Queue, assignmentID uint) error {
+	_, err := queue.Enqueue(JobKind, job{AssignmentID: assignmentID})
+	return err
+}
+
+// Snapshotter records the deadline commit of submissions and tags it in
+// their repositories.
+type Snapshotter struct {
+	cfg   *config.Config
+	queue *jobs.Queue
+}
+
+func NewSnapshotter(cfg *config.Config, queue *jobs.Queue) *Snapshotter {
+	return &Snapshotter{cfg: cfg, queue: queue}
+}
+
+// ProcessJob snapshots the submissions of the assignment. Submissions done
+// in an earlier attempt are skipped, so a retry only handles the failed ones.
+func (s *Snapshotter) ProcessJob(j *models.Job) error {
+	var payload job
+	if err := json.Unmarshal([]byte(j.Payload), &payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	var assignment models.Assignment
+	err := database.DB.Preload("Course").First(&assignment, payload.AssignmentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.cfg.GiteaAdminToken == "" {
+		return errors.New("Gitea admin token not configured")
+	}
+	giteaService, err := services.NewGiteaService(s.cfg.GiteaURL, s.cfg.GiteaAdminToken)
+	if err != nil {
+		return err
+	}
+
+	var submissions []models.Submission
+	err = database.DB.Where("assignment_id = ? AND repo_url <> '' AND (deadline_sha = '' OR deadline_tagged_at IS NULL)", assignment.ID).
+		Find(&submissions).Error
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i := range submissions {
+		if err := s.snapshot(giteaService, &assignment, &submissions[i]); err != nil {
+			log.Printf("Failed to snapshot submission %d at the deadline: %v", submissions[i].ID, err)
+			failed++
+		}
+	}
+
+	log.Printf("Deadline of assignment %d passed, snapshotted %d submissions, %d failed",
+		assignment.ID, len(submissions)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d submissions could not be snapshotted", failed, len(submissions))
+	}
+	return nil
+}
+
+// snapshot records the deadline commit of the submission once, queues its
+// deadline grading run and points the protected deadline tag at the commit.
+func (s *Snapshotter) snapshot(giteaService *services.GiteaService, assignment *models.Assignment, submission *models.Submission) error {
+	org := assignment.Course.OrgName
+	repo := review.RepoNameFromURL(submission.RepoURL)
+
+	if submission.DeadlineSHA == "" {
+		sha, err := headAt(giteaService, org, repo, submission.ID, assignment.Deadline)
+		if err != nil {
+			return err
+		}
+
+		result := database.DB.Model(&models.Submission{}).
+			Where("id = ? AND deadline_sha = ''", submission.ID).
+			Update("deadline_sha", sha)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		submission.DeadlineSHA = sha
+
+		if assignment.GradeAtDeadline && grading.Enabled(assignment) && grading.SandboxConfigured(s.cfg) {
+			if _, err := grading.Enqueue(s.queue, submission, sha, models.GradingTriggerDeadline, nil); err != nil {
+				log.Printf("Warning: failed to queue deadline grading of submission %d: %v", submission.ID, err)
+			}
+		}
+	}
+
+	if err := giteaService.ProtectTag(org, repo, Tag); err != nil {
+		return fmt.Errorf("failed to protect the %s tag: %w", Tag, err)
+	}
+	message := fmt.Sprintf("%s at the deadline of %s (%s)", branch, assignment.Title, assignment.Deadline.Format(time.RFC3339))
+	if err := giteaService.SetTag(org, repo, Tag, submission.DeadlineSHA, message); err != nil {
+		return fmt.Errorf("failed to tag %s: %w", submission.DeadlineSHA, err)
+	}
+
+	return database.DB.Model(&models.Submission{}).
+		Where("id = ? AND deadline_sha = ?", submission.ID, submission.DeadlineSHA).
+		Update("deadline_tagged_at", time.Now()).Error
+}
+
+// headAt returns the head of the branch at the deadline. The snapshot may
+// be taken a while after it, so commits recorded as pushed after the
+// deadline are walked back along their first parent.
+func headAt(giteaService *services.GiteaService, org, repo string, submissionID uint, deadline time.Time) (string, error) {
+	sha, err := giteaService.ResolveCommit(org, repo, branch)
+	if err != nil {
+		return "", err
+	}
+
+	for i := 0; i < maxLateCommits; i++ {
+		var late int64
+		err := database.DB.Model(&models.Commit{}).
+			Where("submission_id = ? AND sha = ? AND pushed_at > ?", submissionID, sha, deadline).
+			Count(&late).Error
+		if err != nil {
+			return "", err
+		}
+		if late == 0 {
+			return sha, nil
+		}
+
+		parent, err := giteaService.CommitParent(org, repo, sha)
+		if err != nil {
+			return "", err
+		}
+		if parent == "" {
+			return "", fmt.Errorf("%s/%s has no commit before the deadline", org, repo)
+		}
+		sha = parent
+	}
+	return "", fmt.Errorf("more than %d commits of %s/%s were pushed after the deadline", maxLateCommits, org, repo)
+}
@@ -0,0 +1,105 @@
+package grading
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLogBytes caps the grader output kept on a run
+const maxLogBytes = 64 << 10
+
+// execSpec is a command to run in a grading workspace
+type execSpec struct {
+	sandbox   *Sandbox
+	dir       string
+	command   string
+	timeLimit time.Duration
+	memoryMB  int
+	env       []string
+	stdin     string
+}
+
+// outcome is how the grader command ended. log has stdout and stderr
+// interleaved, stdout only the standard output.
+type outcome struct {
+	exitCode int
+	timedOut bool
+	log      string
+	stdout   string
+}
+
+// execute runs the command with sh in the workspace, inside the sandbox.
+// The container is removed at the time limit and gets an address space
+// limit on top of its memory limit.
+func execute(spec execSpec) (outcome, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), spec.timeLimit)
+	defer cancel()
+
+	script := fmt.Sprintf("ulimit -v %d && %s", spec.memoryMB*1024, spec.command)
+	cmd := spec.sandbox.command(ctx, spec, script)
+	cmd.Stdin = strings.NewReader(spec.stdin)
+
+	// Background processes holding the output open must not block the run
+	cmd.WaitDelay = 5 * time.Second
+
+	output := &cappedBuffer{limit: maxLogBytes}
+	stdout := &cappedBuffer{limit: maxLogBytes}
+	cmd.Stdout = io.MultiWriter(output, stdout)
+	cmd.Stderr = output
+
+	err := cmd.Run()
+	result := outcome{log: output.String(), stdout: stdout.String()}
+	if ctx.Err() == context.DeadlineExceeded {
+		result.timedOut = true
+		result.exitCode = -1
+		return result, nil
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.exitCode = exitErr.ExitCode()
+	default:
+		return result, err
+	}
+	return result, nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it. It is written
+// from the stdout and stderr copying goroutines at once.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	limit     int
+	data      []byte
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := b.limit - len(b.data); room < len(p) {
+		b.data = append(b.data, p[:max(room, 0)]...)
+		b.truncated = true
+	} else {
+		b.data = append(b.data, p...)
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.truncated {
+		return string(b.data) + "\n[output truncated]\n"
+	}
+	return string(b.data)
+}
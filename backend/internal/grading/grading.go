@@ -0,0 +1,480 @@
+package grading
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+	"gorm.io/gorm"
+)
+
+// JobKind is the job queue kind of grading runs
+const JobKind = "autograde"
+
+const (
+	DefaultTimeLimit   = 5 * time.Minute
+	DefaultMemoryLimit = 1024 // MB
+
+	// MaxTimeLimit leaves the checkout and the container start time to
+	// stay below the time after which the job queue takes a run for lost
+	MaxTimeLimit = jobs.StaleAfter - runOverhead
+	runOverhead  = 5 * time.Minute
+)
+
+type job struct {
+	RunID uint `json:"run_id"`
+}
+
+// Enabled reports whether the assignment has autograding configured: a
+// grading command, hidden tests or an autograding.json.
+func Enabled(assignment *models.Assignment) bool {
+	return assignment.GradingCommand != "" || assignment.TestsRepo != "" ||
+		assignment.GradingResultFormat == FormatAutograding
+}
+
+// TimeLimit is the time limit of the assignment grader.
+func TimeLimit(assignment *models.Assignment) time.Duration {
+	limit := time.Duration(assignment.GradingTimeLimit) * time.Second
+	if limit <= 0 {
+		return DefaultTimeLimit
+	}
+	return min(limit, MaxTimeLimit)
+}
+
+// MemoryLimit is the memory limit of the assignment grader in megabytes.
+func MemoryLimit(assignment *models.Assignment) int {
+	if assignment.GradingMemoryLimit <= 0 {
+		return DefaultMemoryLimit
+	}
+	return assignment.GradingMemoryLimit
+}
+
+// Enqueue records a grading run of the submission at ref and queues it. An
+// empty ref grades the default branch.
+func Enqueue(queue *jobs.Queue, submission *models.Submission, ref, trigger string, requestedBy *uint) (*models.GradingRun, error) {
+	run := &models.GradingRun{
+		SubmissionID: submission.ID,
+		Trigger:      trigger,
+		RequestedBy:  requestedBy,
+		Ref:          ref,
+		Status:       models.GradingStatusQueued,
+	}
+	if err := database.DB.Create(run).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := queue.Enqueue(JobKind, job{RunID: run.ID}); err != nil {
+		database.DB.Model(run).Updates(map[string]interface{}{
+			"status": models.GradingStatusError,
+			"error":  "failed to queue: " + err.Error(),
+		})
+		return nil, err
+	}
+
+	log.Printf("Queued %s grading run %d of submission %d at %q", trigger, run.ID, submission.ID, ref)
+	return run, nil
+}
+
+// Runner checks submissions out of Gitea and runs the assignment grader in
+// the sandbox.
+type Runner struct {
+	cfg     *config.Config
+	sandbox *Sandbox
+}
+
+func NewRunner(cfg *config.Config) *Runner {
+	sandbox, err := NewSandbox(cfg)
+	if err != nil {
+		log.Printf("Warning: autograding disabled: %v", err)
+	}
+	return &Runner{cfg: cfg, sandbox: sandbox}
+}
+
+// ProcessJob runs a queued grading run. Failures to prepare the run are
+// retried, the run is finished as an error on the last attempt.
+func (r *Runner) ProcessJob(j *models.Job) error {
+	var payload job
+	if err := json.Unmarshal([]byte(j.Payload), &payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	var run models.GradingRun
+	if err := database.DB.Preload("Submission.Assignment.Course").First(&run, payload.RunID).Error; err != nil {
+		return err
+	}
+	if run.Status != models.GradingStatusQueued && run.Status != models.GradingStatusRunning {
+		return nil
+	}
+
+	err := r.run(&run)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("Grading run %d failed: %v", run.ID, err)
+	if !jobs.IsLastAttempt(j, err) {
+		database.DB.Model(&run).Updates(map[string]interface{}{
+			"status": models.GradingStatusQueued,
+			"error":  err.Error(),
+		})
+		return err
+	}
+	run.Status = models.GradingStatusError
+	run.Error = err.Error()
+	if finishErr := finish(&run); finishErr != nil {
+		return finishErr
+	}
+	return jobs.Permanent(err)
+}
+
+func (r *Runner) run(run *models.GradingRun) error {
+	submission := &run.Submission
+	assignment := &submission.Assignment
+	if !Enabled(assignment) {
+		return jobs.Permanent(errors.New("autograding is not configured for the assignment"))
+	}
+	if r.sandbox == nil {
+		return jobs.Permanent(errNoSandbox)
+	}
+	if r.cfg.GiteaAdminToken == "" {
+		return errors.New("Gitea admin token not configured")
+	}
+
+	giteaService, err := services.NewGiteaService(r.cfg.GiteaURL, r.cfg.GiteaAdminToken)
+	if err != nil {
+		return err
+	}
+	org := assignment.Course.OrgName
+	repo := review.RepoNameFromURL(submission.RepoURL)
+
+	sha, err := resolve(giteaService, org, repo, run.Ref)
+	if err != nil {
+		return err
+	}
+	testsSHA := ""
+	if assignment.TestsRepo != "" {
+		if testsSHA, err = resolve(giteaService, org, assignment.TestsRepo, assignment.TestsRef); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	run.SHA = sha
+	run.TestsSHA = testsSHA
+	run.StartedAt = &now
+	err = database.DB.Model(run).Updates(map[string]interface{}{
+		"sha":        sha,
+		"tests_sha":  testsSHA,
+		"status":     models.GradingStatusRunning,
+		"started_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp(r.cfg.GradingWorkDir, "grading-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkout(giteaService, org, repo, sha, dir); err != nil {
+		return err
+	}
+
+	// Hidden tests are mounted over student files of the same name
+	sandbox := r.sandbox
+	var manifest *Manifest
+	if testsSHA != "" {
+		testsDir, err := os.MkdirTemp(r.cfg.GradingWorkDir, "grading-tests-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(testsDir)
+
+		if err := checkout(giteaService, org, assignment.TestsRepo, testsSHA, testsDir); err != nil {
+			return err
+		}
+		if sandbox, err = sandbox.withTests(dir, testsDir); err != nil {
+			return jobs.Permanent(err)
+		}
+		if err := sandbox.prepare(testsDir); err != nil {
+			return err
+		}
+		data, err := giteaService.GetFile(org, assignment.TestsRepo, testsSHA, ManifestFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", ManifestFile, err)
+		}
+		if data != nil {
+			if manifest, err = parseManifest(data, assignment); err != nil {
+				return jobs.Permanent(err)
+			}
+		}
+	}
+
+	if err := sandbox.prepare(dir); err != nil {
+		return err
+	}
+
+	run.Error = ""
+	switch {
+	case manifest != nil && len(manifest.Tests) > 0:
+		err = runTests(sandbox, dir, assignment, manifestTests(manifest, assignment), run)
+	case assignment.GradingResultFormat == FormatAutograding:
+		err = runAutograding(sandbox, giteaService, dir, assignment, testsSHA, run)
+	case assignment.GradingCommand == "":
+		return jobs.Permanent(errNothingToRun)
+	default:
+		var weights map[string]int
+		if manifest != nil {
+			weights = manifest.Cases
+		}
+		err = runCommand(sandbox, dir, assignment, weights, run)
+	}
+	if err != nil {
+		return err
+	}
+
+	capPoints(run, assignment.MaxPoints)
+	return finish(run)
+}
+
+// capPoints keeps extra credit from going above the assignment points.
+func capPoints(run *models.GradingRun, maxPoints int) {
+	if run.Points != nil && maxPoints > 0 && *run.Points > maxPoints {
+		capped := maxPoints
+		run.Points = &capped
+	}
+}
+
+// runAutograding runs the tests of the autograding.json of the hidden tests
+// or else of the template. The copy in the submission is never used, the
+// student can edit it.
+func runAutograding(sandbox *Sandbox, giteaService *services.GiteaService, dir string, assignment *models.Assignment, testsSHA string, run *models.GradingRun) error {
+	data, source, err := autogradingFile(giteaService, assignment, testsSHA)
+	if err != nil {
+		return err
+	}
+	var tests []testCase
+	if data == nil {
+		err = fmt.Errorf("%s not found in the tests or template repository", AutogradingFile)
+	} else {
+		tests, err = parseAutograding(data)
+	}
+	if err != nil {
+		points := 0
+		run.Status = models.GradingStatusFailed
+		run.Points = &points
+		run.Log = err.Error() + "\n"
+		return nil
+	}
+
+	log.Printf("Grading run %d uses the %s of %s", run.ID, AutogradingFile, source)
+	return runTests(sandbox, dir, assignment, tests, run)
+}
+
+// autogradingFile reads the autograding.json of the tests repository at
+// testsSHA, or of the template at the head of its default branch. source
+// names the repository and commit it came from.
+func autogradingFile(giteaService *services.GiteaService, assignment *models.Assignment, testsSHA string) ([]byte, string, error) {
+	org := assignment.Course.OrgName
+	if testsSHA != "" {
+		data, err := giteaService.GetFile(org, assignment.TestsRepo, testsSHA, AutogradingFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read %s: %w", AutogradingFile, err)
+		}
+		if data != nil {
+			return data, fmt.Sprintf("%s/%s at %s", org, assignment.TestsRepo, testsSHA), nil
+		}
+	}
+
+	parts := strings.Split(assignment.TemplateRepo, "/")
+	if len(parts) < 2 {
+		return nil, "", nil
+	}
+	owner, repo := parts[len(parts)-2], parts[len(parts)-1]
+	sha, err := resolve(giteaService, owner, repo, "")
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := giteaService.GetFile(owner, repo, sha, AutogradingFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", AutogradingFile, err)
+	}
+	return data, fmt.Sprintf("%s/%s at %s", owner, repo, sha), nil
+}
+
+// runCommand runs the grading command and scores its results: the exit
+// code, or the JUnit or TAP test cases it reports.
+func runCommand(sandbox *Sandbox, dir string, assignment *models.Assignment, weights map[string]int, run *models.GradingRun) error {
+	result, err := execute(execSpec{
+		sandbox:   sandbox,
+		dir:       dir,
+		command:   assignment.GradingCommand,
+		timeLimit: TimeLimit(assignment),
+		memoryMB:  MemoryLimit(assignment),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start the grader: %w", err)
+	}
+
+	run.Log = result.log
+	run.ExitCode = &result.exitCode
+	if result.timedOut {
+		run.Log += fmt.Sprintf("\nTime limit of %v exceeded\n", TimeLimit(assignment))
+	}
+
+	var cases []caseResult
+	switch assignment.GradingResultFormat {
+	case FormatJUnit:
+		cases, err = junitResults(dir, assignment.GradingResultPath)
+	case FormatTAP:
+		cases, err = tapResults(dir, assignment.GradingResultPath, result.stdout)
+	default:
+		points := 0
+		switch {
+		case result.timedOut:
+			run.Status = models.GradingStatusTimeout
+		case result.exitCode == 0:
+			run.Status = models.GradingStatusPassed
+			points = assignment.MaxPoints
+		default:
+			run.Status = models.GradingStatusFailed
+		}
+		run.Points = &points
+		return nil
+	}
+
+	// Missing or broken results, e.g. after a failed build, score nothing
+	if err != nil {
+		run.Log += fmt.Sprintf("\nNo test results: %v\n", err)
+	}
+	applyResults(run, score(cases, weights, assignment.MaxPoints), result.timedOut)
+	return nil
+}
+
+func junitResults(dir, pattern string) ([]caseResult, error) {
+	if pattern == "" {
+		pattern = DefaultJUnitPath
+	}
+	files, err := readResultFiles(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file matches %s", pattern)
+	}
+
+	var cases []caseResult
+	for _, data := range files {
+		fileCases, err := parseJUnit(data)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, fileCases...)
+	}
+	return cases, nil
+}
+
+// tapResults parses the TAP file, or the standard output without a path.
+func tapResults(dir, path, stdout string) ([]caseResult, error) {
+	if path == "" {
+		return parseTAP(stdout)
+	}
+	files, err := readResultFiles(dir, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file matches %s", path)
+	}
+
+	var cases []caseResult
+	for _, data := range files {
+		fileCases, err := parseTAP(string(data))
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, fileCases...)
+	}
+	return cases, nil
+}
+
+// resolve returns the commit SHA of ref, the default branch when ref is empty.
+func resolve(giteaService *services.GiteaService, owner, repo, ref string) (string, error) {
+	if ref == "" {
+		repository, err := giteaService.GetRepository(owner, repo)
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s/%s: %w", owner, repo, err)
+		}
+		ref = repository.DefaultBranch
+	}
+	sha, err := giteaService.ResolveCommit(owner, repo, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s of %s/%s: %w", ref, owner, repo, err)
+	}
+	return sha, nil
+}
+
+// checkout unpacks the repository at sha into dir.
+func checkout(giteaService *services.GiteaService, owner, repo, sha, dir string) error {
+	archive, err := giteaService.GetArchive(owner, repo, sha)
+	if err != nil {
+		return fmt.Errorf("failed to download %s/%s at %s: %w", owner, repo, sha, err)
+	}
+	defer archive.Close()
+
+	if err := extract(archive, dir); err != nil {
+		return fmt.Errorf("failed to unpack %s/%s at %s: %w", owner, repo, sha, err)
+	}
+	return nil
+}
+
+// finish stores the run outcome and its test results and, unless a newer
+// run already finished, copies it to the submission.
+func finish(run *models.GradingRun) error {
+	now := time.Now()
+	run.FinishedAt = &now
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(run).Select("status", "exit_code", "points", "log", "error", "finished_at").
+			Updates(run).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range run.Tests {
+			run.Tests[i].RunID = run.ID
+		}
+		if len(run.Tests) > 0 {
+			if err := tx.Create(&run.Tests).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.Submission{}).
+			Where("id = ? AND (autograde_run_id IS NULL OR autograde_run_id <= ?)", run.SubmissionID, run.ID).
+			Updates(map[string]interface{}{
+				"autograde_run_id": run.ID,
+				"autograde_status": run.Status,
+				"autograde_points": run.Points,
+				"autograded_at":    now,
+			}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Grading run %d of submission %d at %s: %s", run.ID, run.SubmissionID, run.SHA, run.Status)
+	return nil
+}
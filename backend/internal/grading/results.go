@@ -0,0 +1,306 @@
+package grading
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// Result formats of the grading command output
+const (
+	FormatExitCode    = "exit_code"
+	FormatJUnit       = "junit"
+	FormatTAP         = "tap"
+	FormatAutograding = "autograding"
+)
+
+// ResultFormats are the supported result formats, empty means exit_code
+var ResultFormats = []string{FormatExitCode, FormatJUnit, FormatTAP, FormatAutograding}
+
+// IsResultFormat reports whether format is a supported result format.
+func IsResultFormat(format string) bool {
+	for _, f := range ResultFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultJUnitPath is where JUnit reports are read when no path is set
+const DefaultJUnitPath = "junit.xml"
+
+// maxResultBytes caps the size of a result file read from the workspace
+const maxResultBytes = 8 << 20
+
+// caseResult is the outcome of one test case. Points are set when the case
+// carries its own points, otherwise they come from weights or an even split.
+type caseResult struct {
+	Class    string
+	Name     string
+	Passed   bool
+	Feedback string
+	Points   *int
+	Output   string
+}
+
+func (c caseResult) fullName() string {
+	if c.Class == "" {
+		return c.Name
+	}
+	return c.Class + "." + c.Name
+}
+
+// score turns case outcomes into test results. Cases with own points keep
+// them. Otherwise weights give the points by full or short case name, and
+// without weights maxPoints is split evenly between the cases.
+func score(cases []caseResult, weights map[string]int, maxPoints int) []models.GradingTestResult {
+	own := false
+	for _, c := range cases {
+		if c.Points != nil {
+			own = true
+			break
+		}
+	}
+
+	results := make([]models.GradingTestResult, len(cases))
+	for i, c := range cases {
+		var worth int
+		switch {
+		case own:
+			if c.Points != nil {
+				worth = *c.Points
+			}
+		case len(weights) > 0:
+			if w, ok := weights[c.fullName()]; ok {
+				worth = w
+			} else {
+				worth = weights[c.Name]
+			}
+		default:
+			worth = maxPoints / len(cases)
+			if i < maxPoints%len(cases) {
+				worth++
+			}
+		}
+
+		results[i] = models.GradingTestResult{
+			Name:      c.fullName(),
+			Passed:    c.Passed,
+			MaxPoints: worth,
+			Feedback:  c.Feedback,
+			Output:    truncate(c.Output, maxTestOutputBytes),
+		}
+		if c.Passed {
+			results[i].Points = worth
+		}
+	}
+	return results
+}
+
+type junitSuite struct {
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string         `xml:"name,attr"`
+	Classname string         `xml:"classname,attr"`
+	Failures  []junitProblem `xml:"failure"`
+	Errors    []junitProblem `xml:"error"`
+	Skipped   *junitProblem  `xml:"skipped"`
+	SystemOut string         `xml:"system-out"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (p junitProblem) feedback() string {
+	if p.Message != "" {
+		return p.Message
+	}
+	text := strings.TrimSpace(p.Text)
+	if line, _, ok := strings.Cut(text, "\n"); ok {
+		return line
+	}
+	return text
+}
+
+// parseJUnit reads the test cases of a JUnit XML report, with a testsuites
+// or a testsuite root.
+func parseJUnit(data []byte) ([]caseResult, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var cases []caseResult
+	var walk func(suite junitSuite)
+	walk = func(suite junitSuite) {
+		for _, tc := range suite.Cases {
+			c := caseResult{Class: tc.Classname, Name: tc.Name, Passed: true, Output: tc.SystemOut}
+			switch {
+			case len(tc.Failures) > 0:
+				c.Passed = false
+				c.Feedback = tc.Failures[0].feedback()
+			case len(tc.Errors) > 0:
+				c.Passed = false
+				c.Feedback = tc.Errors[0].feedback()
+			case tc.Skipped != nil:
+				c.Passed = false
+				c.Feedback = "skipped"
+				if reason := tc.Skipped.feedback(); reason != "" {
+					c.Feedback += ": " + reason
+				}
+			}
+			cases = append(cases, c)
+		}
+		for _, child := range suite.Suites {
+			walk(child)
+		}
+	}
+	walk(root)
+	return cases, nil
+}
+
+var (
+	tapLine = regexp.MustCompile(`^(not )?ok\b\s*(\d+)?\s*(?:-\s*)?([^#]*?)\s*(?:#\s*(.*))?$`)
+	tapPlan = regexp.MustCompile(`^\d+\.\.\d+`)
+)
+
+// parseTAP reads the test points of TAP output. Diagnostics following a
+// failed point become its feedback, SKIP and TODO points earn nothing.
+func parseTAP(output string) ([]caseResult, error) {
+	var cases []caseResult
+	var diagnostics []string
+	flush := func() {
+		if len(cases) > 0 && len(diagnostics) > 0 && !cases[len(cases)-1].Passed {
+			last := &cases[len(cases)-1]
+			if last.Feedback != "" {
+				diagnostics = append([]string{last.Feedback}, diagnostics...)
+			}
+			last.Feedback = strings.Join(diagnostics, "\n")
+		}
+		diagnostics = nil
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "Bail out!") {
+			break
+		}
+
+		m := tapLine.FindStringSubmatch(line)
+		if m == nil {
+			// Comments and YAML blocks describe the previous point
+			if trimmed != "" && trimmed != "---" && trimmed != "..." &&
+				!strings.HasPrefix(trimmed, "TAP version") && !tapPlan.MatchString(trimmed) {
+				diagnostics = append(diagnostics, strings.TrimPrefix(strings.TrimPrefix(trimmed, "#"), " "))
+			}
+			continue
+		}
+		flush()
+
+		name := m[3]
+		if name == "" {
+			name = "test " + m[2]
+			if m[2] == "" {
+				name = "test " + strconv.Itoa(len(cases)+1)
+			}
+		}
+		c := caseResult{Name: name, Passed: m[1] == ""}
+
+		directive := strings.ToUpper(m[4])
+		switch {
+		case strings.HasPrefix(directive, "SKIP"):
+			c.Passed = false
+			c.Feedback = "skipped"
+		case strings.HasPrefix(directive, "TODO"):
+			c.Passed = false
+			c.Feedback = "todo"
+		}
+		cases = append(cases, c)
+	}
+	flush()
+
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no TAP test points found")
+	}
+	return cases, nil
+}
+
+// readResultFiles returns the content of the workspace files matching the
+// glob pattern in name order. Links leaving the workspace are refused, the
+// grader must not make the server read its files.
+func readResultFiles(dir, pattern string) ([][]byte, error) {
+	if _, err := within(dir, pattern); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files [][]byte
+	for _, path := range paths {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(realDir, real)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := within(realDir, rel); err != nil {
+			return nil, fmt.Errorf("result file %s is outside the workspace", pattern)
+		}
+
+		info, err := os.Stat(real)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		data, err := readCapped(real)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, data)
+	}
+	return files, nil
+}
+
+func readCapped(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxResultBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResultBytes {
+		return nil, fmt.Errorf("result file %s is larger than %d MB", filepath.Base(path), maxResultBytes>>20)
+	}
+	return data, nil
+}
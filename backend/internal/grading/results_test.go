@@ -0,0 +1,228 @@
+package grading
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+func points(p int) *int { return &p }
+
+func TestParseJUnit(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  string
+		want    []caseResult
+		wantErr bool
+	}{
+		{
+			name: "single suite",
+			report: `<testsuite name="calc">
+				<testcase classname="Calc" name="add"/>
+				<testcase classname="Calc" name="sub"><failure message="expected 1, got 2">trace</failure></testcase>
+			</testsuite>`,
+			want: []caseResult{
+				{Class: "Calc", Name: "add", Passed: true},
+				{Class: "Calc", Name: "sub", Feedback: "expected 1, got 2"},
+			},
+		},
+		{
+			name: "nested suites",
+			report: `<testsuites>
+				<testsuite name="outer">
+					<testcase name="first"/>
+					<testsuite name="inner">
+						<testcase name="second"><system-out>hello</system-out></testcase>
+					</testsuite>
+				</testsuite>
+				<testsuite name="other"><testcase name="third"/></testsuite>
+			</testsuites>`,
+			want: []caseResult{
+				{Name: "first", Passed: true},
+				{Name: "second", Passed: true, Output: "hello"},
+				{Name: "third", Passed: true},
+			},
+		},
+		{
+			name: "errors and skipped cases",
+			report: `<testsuite>
+				<testcase name="crash"><error>panic: nil map
+goroutine 1</error></testcase>
+				<testcase name="later"><skipped message="not implemented"/></testcase>
+				<testcase name="plain"><skipped/></testcase>
+			</testsuite>`,
+			want: []caseResult{
+				{Name: "crash", Feedback: "panic: nil map"},
+				{Name: "later", Feedback: "skipped: not implemented"},
+				{Name: "plain", Feedback: "skipped"},
+			},
+		},
+		{
+			name:    "not XML",
+			report:  `PASS ok`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJUnit([]byte(tt.report))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJUnit() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseJUnit() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTAP(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []caseResult
+		wantErr bool
+	}{
+		{
+			name: "passed and failed points",
+			output: `TAP version 13
+1..3
+ok 1 - parses input
+not ok 2 - handles overflow
+  ---
+  message: expected 0
+  ...
+# got 255
+ok 3`,
+			want: []caseResult{
+				{Name: "parses input", Passed: true},
+				{Name: "handles overflow", Feedback: "message: expected 0\ngot 255"},
+				{Name: "test 3", Passed: true},
+			},
+		},
+		{
+			name: "SKIP and TODO earn nothing",
+			output: `ok 1 - network # SKIP no network
+ok 2 - streaming # skip
+not ok 3 - unicode # TODO later`,
+			want: []caseResult{
+				{Name: "network", Feedback: "skipped"},
+				{Name: "streaming", Feedback: "skipped"},
+				{Name: "unicode", Feedback: "todo"},
+			},
+		},
+		{
+			name: "bail out stops reading",
+			output: `ok 1 - first
+Bail out! database gone
+ok 2 - never`,
+			want: []caseResult{{Name: "first", Passed: true}},
+		},
+		{
+			name:    "no test points",
+			output:  "all good\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTAP(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTAP() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseTAP() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name       string
+		cases      []caseResult
+		weights    map[string]int
+		maxPoints  int
+		wantPoints []int
+		wantMax    []int
+	}{
+		{
+			name:       "even split with remainder",
+			cases:      []caseResult{{Name: "a", Passed: true}, {Name: "b", Passed: true}, {Name: "c"}},
+			maxPoints:  10,
+			wantPoints: []int{4, 3, 0},
+			wantMax:    []int{4, 3, 3},
+		},
+		{
+			name:       "weights by full and short name",
+			cases:      []caseResult{{Class: "T", Name: "a", Passed: true}, {Class: "T", Name: "b", Passed: true}, {Name: "c", Passed: true}},
+			weights:    map[string]int{"T.a": 5, "b": 2},
+			maxPoints:  10,
+			wantPoints: []int{5, 2, 0},
+			wantMax:    []int{5, 2, 0},
+		},
+		{
+			name:       "own points win over weights",
+			cases:      []caseResult{{Name: "a", Passed: true, Points: points(7)}, {Name: "b", Passed: true}},
+			weights:    map[string]int{"b": 3},
+			maxPoints:  10,
+			wantPoints: []int{7, 0},
+			wantMax:    []int{7, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := score(tt.cases, tt.weights, tt.maxPoints)
+			var gotPoints, gotMax []int
+			for _, r := range results {
+				gotPoints = append(gotPoints, r.Points)
+				gotMax = append(gotMax, r.MaxPoints)
+			}
+			if !reflect.DeepEqual(gotPoints, tt.wantPoints) || !reflect.DeepEqual(gotMax, tt.wantMax) {
+				t.Fatalf("score() points = %v of %v, want %v of %v", gotPoints, gotMax, tt.wantPoints, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestCapPoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    *int
+		maxPoints int
+		want      *int
+	}{
+		{name: "extra credit is capped", points: points(12), maxPoints: 10, want: points(10)},
+		{name: "below the maximum", points: points(7), maxPoints: 10, want: points(7)},
+		{name: "no maximum", points: points(12), maxPoints: 0, want: points(12)},
+		{name: "no points", points: nil, maxPoints: 10, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := &models.GradingRun{Points: tt.points}
+			capPoints(run, tt.maxPoints)
+			if !reflect.DeepEqual(run.Points, tt.want) {
+				t.Fatalf("capPoints() = %v, want %v", run.Points, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyResultsCapsWithScore(t *testing.T) {
+	run := &models.GradingRun{}
+	cases := []caseResult{{Name: "a", Passed: true, Points: points(8)}, {Name: "bonus", Passed: true, Points: points(5)}}
+	applyResults(run, score(cases, nil, 10), false)
+	capPoints(run, 10)
+
+	if run.Status != models.GradingStatusPassed {
+		t.Fatalf("status = %s, want %s", run.Status, models.GradingStatusPassed)
+	}
+	if run.Points == nil || *run.Points != 10 {
+		t.Fatalf("points = %v, want 10", run.Points)
+	}
+}
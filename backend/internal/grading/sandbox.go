@@ -0,0 +1,192 @@
+package grading
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+)
+
+const (
+	// sandboxWorkdir is where the workspace is mounted in the container
+	sandboxWorkdir = "/workspace"
+
+	// sandboxPids caps the processes of a grader
+	sandboxPids = 256
+
+	// nobody is the user graders run as when the server runs as root
+	nobody = 65534
+)
+
+// errNoSandbox is returned for grading runs when no sandbox is configured,
+// student code is never run next to the server.
+var errNoSandbox = errors.New("autograding needs a sandbox, set GRADING_SANDBOX and GRADING_IMAGE")
+
+// containers numbers the grader containers of this server
+var containers atomic.Uint64
+
+// Sandbox runs grader commands in a throwaway container without network,
+// capabilities or any host files but the workspace, as an unprivileged user.
+type Sandbox struct {
+	runtime string
+	image   string
+	uid     int
+	gid     int
+
+	// Hidden tests checkout and its files, mounted read-only over the
+	// workspace
+	testsDir  string
+	testFiles []string
+}
+
+// NewSandbox returns the sandbox configured for grading.
+func NewSandbox(cfg *config.Config) (*Sandbox, error) {
+	switch cfg.GradingSandbox {
+	case "":
+		return nil, errNoSandbox
+	case "docker", "podman":
+	default:
+		return nil, fmt.Errorf("unknown grading sandbox %q, use docker or podman", cfg.GradingSandbox)
+	}
+	if cfg.GradingImage == "" {
+		return nil, errors.New("GRADING_IMAGE is required by the grading sandbox")
+	}
+
+	// The workspace belongs to the server user, which is used unless it
+	// is root
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = nobody, nobody
+	}
+	return &Sandbox{runtime: cfg.GradingSandbox, image: cfg.GradingImage, uid: uid, gid: gid}, nil
+}
+
+// SandboxConfigured reports whether graders can be run.
+func SandboxConfigured(cfg *config.Config) bool {
+	_, err := NewSandbox(cfg)
+	return err == nil
+}
+
+// withTests returns the sandbox mounting the files of the hidden tests
+// checkout read-only over the workspace. Graders can run the tests but not
+// change them, the workspace gets an empty file at each mount point.
+func (s *Sandbox) withTests(dir, testsDir string) (*Sandbox, error) {
+	var files []string
+	err := filepath.WalkDir(testsDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(testsDir, file)
+		if err != nil {
+			return err
+		}
+		// The runtime mount syntax cannot carry these
+		if strings.ContainsAny(rel, ":,") {
+			return fmt.Errorf("hidden test file %q has a colon or comma in its path", rel)
+		}
+
+		target, err := within(dir, rel)
+		if err != nil {
+			return err
+		}
+		// Student links must not carry the mount points out of the workspace
+		if err := noLinks(dir, filepath.Dir(rel)); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, nil, 0o644); err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tests := *s
+	tests.testsDir = testsDir
+	tests.testFiles = files
+	return &tests, nil
+}
+
+// prepare hands the directories to the grader user.
+func (s *Sandbox) prepare(dirs ...string) error {
+	if os.Getuid() != 0 {
+		return nil
+	}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, s.uid, s.gid)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command returns the command running script in a new container. Killing
+// the process group at the time limit leaves the container, so the command
+// removes it on cancel.
+func (s *Sandbox) command(ctx context.Context, spec execSpec, script string) *exec.Cmd {
+	name := fmt.Sprintf("%s-%d", filepath.Base(spec.dir), containers.Add(1))
+	args := []string{
+		"run", "--rm", "-i",
+		"--name", name,
+		"--network", "none",
+		"--user", fmt.Sprintf("%d:%d", s.uid, s.gid),
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--memory", strconv.Itoa(spec.memoryMB) + "m",
+		"--memory-swap", strconv.Itoa(spec.memoryMB) + "m",
+		"--pids-limit", strconv.Itoa(sandboxPids),
+		"--tmpfs", "/tmp",
+		"-v", spec.dir + ":" + sandboxWorkdir,
+	}
+	for _, file := range s.testFiles {
+		source := filepath.Join(s.testsDir, file)
+		target := path.Join(sandboxWorkdir, filepath.ToSlash(file))
+		args = append(args, "-v", source+":"+target+":ro")
+	}
+	args = append(args,
+		"-w", sandboxWorkdir,
+		"-e", "HOME="+sandboxWorkdir,
+		"-e", "TMPDIR=/tmp",
+		"-e", "LANG=C.UTF-8",
+		"-e", "CI=true",
+	)
+	for _, env := range spec.env {
+		args = append(args, "-e", env)
+	}
+	args = append(args, s.image, "sh", "-c", script)
+
+	cmd := exec.CommandContext(ctx, s.runtime, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		removeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		exec.CommandContext(removeCtx, s.runtime, "rm", "-f", name).Run()
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd
+}
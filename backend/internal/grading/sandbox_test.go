@@ -0,0 +1,66 @@
+package grading
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithTestsRefusesLinks(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "workspace")
+	testsDir := filepath.Join(parent, "tests")
+	host := filepath.Join(parent, "host")
+	for _, d := range []string{dir, filepath.Join(testsDir, "tests"), host} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(testsDir, "tests", "check.py"), []byte("hidden"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hostFile := filepath.Join(host, "check.py")
+	if err := os.WriteFile(hostFile, []byte("host"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(host, filepath.Join(dir, "tests")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (&Sandbox{}).withTests(dir, testsDir); err == nil {
+		t.Fatal("withTests() went through the student link")
+	}
+	data, err := os.ReadFile(hostFile)
+	if err != nil || string(data) != "host" {
+		t.Fatalf("host file changed: %q, %v", data, err)
+	}
+}
+
+func TestWithTestsMountPoints(t *testing.T) {
+	dir, testsDir := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(testsDir, "tests"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(testsDir, "tests", "check.py"), []byte("hidden"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A student copy of the test is replaced by an empty mount point
+	if err := os.MkdirAll(filepath.Join(dir, "tests"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tests", "check.py"), []byte("student"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox, err := (&Sandbox{}).withTests(dir, testsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sandbox.testFiles) != 1 || sandbox.testFiles[0] != filepath.Join("tests", "check.py") {
+		t.Fatalf("testFiles = %v", sandbox.testFiles)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "tests", "check.py"))
+	if err != nil || len(data) != 0 {
+		t.Fatalf("mount point = %q, %v, want an empty file", data, err)
+	}
+}
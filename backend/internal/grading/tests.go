@@ -0,0 +1,287 @@
+package grading
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// ManifestFile lists the tests of a hidden tests repository
+const ManifestFile = "grading.json"
+
+// AutogradingFile holds the tests of GitHub Classroom style templates
+const AutogradingFile = ".github/classroom/autograding.json"
+
+// maxTestOutputBytes caps the output kept per test
+const maxTestOutputBytes = 8 << 10
+
+// Manifest is the grading.json of a tests repository. Tests are run one by
+// one, Cases give the points of test cases reported in JUnit or TAP results.
+type Manifest struct {
+	Tests []TestSpec     `json:"tests"`
+	Cases map[string]int `json:"cases"`
+}
+
+// TestSpec is one test worth Points. A test runs Command, or the assignment
+// grading command with the path of File in $TEST_FILE.
+type TestSpec struct {
+	Name    string `json:"name"`
+	File    string `json:"file"`
+	Command string `json:"command"`
+	Points  int    `json:"points"`
+}
+
+// parseManifest decodes and checks a manifest for the assignment.
+func parseManifest(data []byte, assignment *models.Assignment) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ManifestFile, err)
+	}
+
+	for i := range manifest.Tests {
+		test := &manifest.Tests[i]
+		if test.Name == "" {
+			test.Name = test.File
+		}
+		switch {
+		case test.Name == "":
+			return nil, fmt.Errorf("%s: test %d has no name", ManifestFile, i+1)
+		case test.Points < 0:
+			return nil, fmt.Errorf("%s: test %q has negative points", ManifestFile, test.Name)
+		case test.Command == "" && test.File == "":
+			return nil, fmt.Errorf("%s: test %q has neither a command nor a file", ManifestFile, test.Name)
+		case test.Command == "" && assignment.GradingCommand == "":
+			return nil, fmt.Errorf("%s: test %q needs the assignment grading command", ManifestFile, test.Name)
+		}
+	}
+	return &manifest, nil
+}
+
+// manifestTests are the tests of a manifest ready to run.
+func manifestTests(manifest *Manifest, assignment *models.Assignment) []testCase {
+	tests := make([]testCase, 0, len(manifest.Tests))
+	for _, spec := range manifest.Tests {
+		test := testCase{name: spec.Name, command: spec.Command, points: &spec.Points}
+		if test.command == "" {
+			test.command = assignment.GradingCommand
+			test.env = []string{"TEST_FILE=" + spec.File}
+		}
+		tests = append(tests, test)
+	}
+	return tests
+}
+
+// autogradingTest is a test of a GitHub Classroom autograding.json. Timeout
+// is in minutes, without Output the test passes on exit code 0.
+type autogradingTest struct {
+	Name       string  `json:"name"`
+	Setup      string  `json:"setup"`
+	Run        string  `json:"run"`
+	Input      string  `json:"input"`
+	Output     string  `json:"output"`
+	Comparison string  `json:"comparison"`
+	Timeout    float64 `json:"timeout"`
+	Points     *int    `json:"points"`
+}
+
+// parseAutograding turns an autograding.json into tests ready to run.
+func parseAutograding(data []byte) ([]testCase, error) {
+	var file struct {
+		Tests []autogradingTest `json:"tests"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", AutogradingFile, err)
+	}
+	if len(file.Tests) == 0 {
+		return nil, fmt.Errorf("%s has no tests", AutogradingFile)
+	}
+
+	tests := make([]testCase, 0, len(file.Tests))
+	for i, t := range file.Tests {
+		if t.Run == "" {
+			return nil, fmt.Errorf("%s: test %d has no run command", AutogradingFile, i+1)
+		}
+		comparison := t.Comparison
+		if comparison == "" {
+			comparison = "included"
+		}
+		if comparison != "exact" && comparison != "included" && comparison != "regex" {
+			return nil, fmt.Errorf("%s: test %q has unknown comparison %q", AutogradingFile, t.Name, comparison)
+		}
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("test %d", i+1)
+		}
+		tests = append(tests, testCase{
+			name:       name,
+			setup:      t.Setup,
+			command:    t.Run,
+			input:      t.Input,
+			expected:   t.Output,
+			comparison: comparison,
+			timeout:    time.Duration(t.Timeout * float64(time.Minute)),
+			points:     t.Points,
+		})
+	}
+	return tests, nil
+}
+
+// testCase is a test run on its own in the workspace.
+type testCase struct {
+	name       string
+	setup      string
+	command    string
+	env        []string
+	input      string
+	expected   string // compared with the standard output when set
+	comparison string // exact, included or regex
+	timeout    time.Duration
+	points     *int
+}
+
+// runTests runs the tests one by one within the time limit of the
+// assignment and scores them.
+func runTests(sandbox *Sandbox, dir string, assignment *models.Assignment, tests []testCase, run *models.GradingRun) error {
+	deadline := time.Now().Add(TimeLimit(assignment))
+
+	cases := make([]caseResult, 0, len(tests))
+	timedOut := false
+	for _, test := range tests {
+		result, expired, err := runTest(sandbox, dir, assignment, test, deadline)
+		if err != nil {
+			return fmt.Errorf("failed to start test %q: %w", test.name, err)
+		}
+		timedOut = timedOut || expired
+		cases = append(cases, result)
+	}
+
+	results := score(cases, nil, assignment.MaxPoints)
+
+	var log strings.Builder
+	for _, result := range results {
+		verdict := "failed"
+		if result.Passed {
+			verdict = "passed"
+		}
+		fmt.Fprintf(&log, "== %s: %s (%d/%d)\n", result.Name, verdict, result.Points, result.MaxPoints)
+		if result.Feedback != "" {
+			fmt.Fprintf(&log, "%s\n", result.Feedback)
+		}
+		fmt.Fprintf(&log, "%s\n", result.Output)
+	}
+	run.Log = truncate(log.String(), maxLogBytes)
+
+	applyResults(run, results, timedOut)
+	return nil
+}
+
+// runTest runs the setup and the command of a test. It reports whether the
+// run time limit expired, a test exceeding its own timeout just fails.
+func runTest(sandbox *Sandbox, dir string, assignment *models.Assignment, test testCase, deadline time.Time) (caseResult, bool, error) {
+	result := caseResult{Name: test.name, Points: test.points}
+
+	limit := time.Until(deadline)
+	ownLimit := test.timeout > 0 && test.timeout < limit
+	if ownLimit {
+		limit = test.timeout
+	}
+	if limit <= 0 {
+		result.Feedback = "not run, time limit exceeded"
+		return result, true, nil
+	}
+	started := time.Now()
+
+	spec := execSpec{sandbox: sandbox, dir: dir, timeLimit: limit, memoryMB: MemoryLimit(assignment), env: test.env}
+	if test.setup != "" {
+		spec.command = test.setup
+		out, err := execute(spec)
+		if err != nil {
+			return result, false, err
+		}
+		result.Output = out.log
+		switch {
+		case out.timedOut:
+			result.Feedback = "setup exceeded the time limit"
+			return result, !ownLimit, nil
+		case out.exitCode != 0:
+			result.Feedback = fmt.Sprintf("setup failed with exit code %d", out.exitCode)
+			return result, false, nil
+		}
+		spec.timeLimit = limit - time.Since(started)
+	}
+
+	spec.command = test.command
+	spec.stdin = test.input
+	out, err := execute(spec)
+	if err != nil {
+		return result, false, err
+	}
+	result.Output += out.log
+
+	switch {
+	case out.timedOut:
+		result.Feedback = "time limit exceeded"
+		return result, !ownLimit, nil
+	case out.exitCode != 0:
+		result.Feedback = fmt.Sprintf("exited with code %d", out.exitCode)
+	case test.expected != "" && !outputMatches(out.stdout, test.expected, test.comparison):
+		result.Feedback = "output does not match the expected output"
+	default:
+		result.Passed = true
+	}
+	return result, false, nil
+}
+
+// outputMatches compares the output with the expected one, ignoring line
+// ending style and surrounding whitespace.
+func outputMatches(output, expected, comparison string) bool {
+	output = strings.TrimSpace(strings.ReplaceAll(output, "\r\n", "\n"))
+	expected = strings.TrimSpace(strings.ReplaceAll(expected, "\r\n", "\n"))
+
+	switch comparison {
+	case "exact":
+		return output == expected
+	case "regex":
+		re, err := regexp.Compile(expected)
+		return err == nil && re.MatchString(output)
+	}
+	return strings.Contains(output, expected)
+}
+
+// applyResults sets the test results, points and status of the run.
+func applyResults(run *models.GradingRun, results []models.GradingTestResult, timedOut bool) {
+	points, passed := 0, 0
+	for _, result := range results {
+		points += result.Points
+		if result.Passed {
+			passed++
+		}
+	}
+
+	run.Tests = results
+	run.Points = &points
+	switch {
+	case timedOut:
+		run.Status = models.GradingStatusTimeout
+	case len(results) > 0 && passed == len(results):
+		run.Status = models.GradingStatusPassed
+	default:
+		run.Status = models.GradingStatusFailed
+	}
+}
+
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "\n[output truncated]\n"
+}
+
+// errNothingToRun is returned for hidden tests without a manifest when the
+// assignment has no grading command either.
+var errNothingToRun = errors.New("no grading command and no " + ManifestFile + " in the tests repository")
@@ -0,0 +1,86 @@
+package grading
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAutograding(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []testCase
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			file: `{"tests": [{"run": "make test"}]}`,
+			want: []testCase{{name: "test 1", command: "make test", comparison: "included"}},
+		},
+		{
+			name: "comparisons, timeouts and points",
+			file: `{"tests": [
+				{"name": "exact", "setup": "make", "run": "./calc", "input": "1 2", "output": "3", "comparison": "exact", "timeout": 1.5, "points": 4},
+				{"name": "regex", "run": "./calc --version", "output": "^v\\d+", "comparison": "regex", "points": 1}
+			]}`,
+			want: []testCase{
+				{name: "exact", setup: "make", command: "./calc", input: "1 2", expected: "3", comparison: "exact",
+					timeout: 90 * time.Second, points: points(4)},
+				{name: "regex", command: "./calc --version", expected: `^v\d+`, comparison: "regex", points: points(1)},
+			},
+		},
+		{name: "no tests", file: `{"tests": []}`, wantErr: true},
+		{name: "no run command", file: `{"tests": [{"name": "a"}]}`, wantErr: true},
+		{name: "unknown comparison", file: `{"tests": [{"run": "true", "comparison": "fuzzy"}]}`, wantErr: true},
+		{name: "not JSON", file: `tests:`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAutograding([]byte(tt.file))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAutograding() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseAutograding() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				g, w := got[i], tt.want[i]
+				samePoints := (g.points == nil && w.points == nil) ||
+					(g.points != nil && w.points != nil && *g.points == *w.points)
+				g.points, w.points = nil, nil
+				if !samePoints || g.name != w.name || g.setup != w.setup || g.command != w.command ||
+					g.input != w.input || g.expected != w.expected || g.comparison != w.comparison || g.timeout != w.timeout {
+					t.Fatalf("test %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOutputMatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		expected   string
+		comparison string
+		want       bool
+	}{
+		{name: "exact", output: "42\n", expected: "42", comparison: "exact", want: true},
+		{name: "exact with CRLF", output: "a\r\nb\r\n", expected: "a\nb", comparison: "exact", want: true},
+		{name: "exact rejects extra output", output: "result: 42", expected: "42", comparison: "exact", want: false},
+		{name: "included", output: "result: 42\n", expected: "42", comparison: "included", want: true},
+		{name: "included missing", output: "result: 41", expected: "42", comparison: "included", want: false},
+		{name: "regex", output: "took 15ms", expected: `^took \d+ms$`, comparison: "regex", want: true},
+		{name: "regex mismatch", output: "took ms", expected: `^took \d+ms$`, comparison: "regex", want: false},
+		{name: "invalid regex fails", output: "(", expected: "(", comparison: "regex", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outputMatches(tt.output, tt.expected, tt.comparison); got != tt.want {
+				t.Fatalf("outputMatches(%q, %q, %s) = %v, want %v", tt.output, tt.expected, tt.comparison, got, tt.want)
+			}
+		})
+	}
+}
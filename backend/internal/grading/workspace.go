@@ -0,0 +1,170 @@
+package grading
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxWorkspaceBytes caps the unpacked size of a checkout
+const maxWorkspaceBytes = 512 << 20
+
+// extract unpacks a Gitea tar.gz archive into dir. Gitea puts everything in
+// a top level directory named after the repository, it is stripped. Files
+// already in dir are replaced, so a second archive overlays the first.
+func extract(archive io.Reader, dir string) error {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	var total int64
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := stripTopDir(header.Name)
+		if name == "" {
+			continue
+		}
+		target, err := within(dir, name)
+		if err != nil {
+			return err
+		}
+		// Nothing is written through links of an earlier entry
+		if err := noLinks(dir, filepath.Dir(name)); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := noLinks(dir, name); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			total += header.Size
+			if total > maxWorkspaceBytes {
+				return fmt.Errorf("repository is larger than %d MB", maxWorkspaceBytes>>20)
+			}
+			if err := writeFile(target, tr, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// Links may only point inside the workspace
+			if filepath.IsAbs(header.Linkname) {
+				continue
+			}
+			if _, err := within(dir, filepath.Join(filepath.Dir(name), header.Linkname)); err != nil {
+				continue
+			}
+			if err := noLinks(dir, filepath.Dir(name)+"/"+header.Linkname); err != nil {
+				continue
+			}
+			// Links checked against a directory must keep pointing at it
+			if info, err := os.Lstat(target); err == nil && info.IsDir() {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := removeFile(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func stripTopDir(name string) string {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// within joins name to dir and fails when the result escapes dir.
+func within(dir, name string) (string, error) {
+	target := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is outside the workspace", name)
+	}
+	return target, nil
+}
+
+// noLinks fails when a component of name, walked from dir before cleaning,
+// is a link on disk or leaves dir. The lexical check of within alone lets
+// chained links such as "e -> ." and "f -> e/.." point outside dir.
+func noLinks(dir, name string) error {
+	current, depth := dir, 0
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if depth--; depth < 0 {
+				return fmt.Errorf("%q is outside the workspace", name)
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+		depth++
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%q goes through a link", name)
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Never write through a link left by the previous archive
+	if err := removeFile(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm|0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package grading
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archive builds a Gitea style tar.gz with the entries under a top level
+// directory.
+func archive(t *testing.T, entries ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, header := range entries {
+		header.Name = "repo/" + header.Name
+		if header.Mode == 0 {
+			header.Mode = 0o644
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(make([]byte, header.Size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func link(name, target string) *tar.Header {
+	return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}
+}
+
+func file(name string) *tar.Header {
+	return &tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 1}
+}
+
+func TestExtractLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+		wantErr bool
+		outside string // must not exist next to the workspace afterwards
+	}{
+		{
+			name:    "link inside the workspace",
+			entries: []*tar.Header{file("src/main.go"), link("main.go", "src/main.go")},
+		},
+		{
+			name:    "chained links",
+			entries: []*tar.Header{link("e", "."), link("f", "e/.."), link("x", "f"), file("x/escaped")},
+			wantErr: true,
+			outside: "escaped",
+		},
+		{
+			name:    "file below a link",
+			entries: []*tar.Header{link("e", "."), file("e/y")},
+			wantErr: true,
+		},
+		{
+			name: "directory replaced by a link",
+			entries: []*tar.Header{
+				{Name: "g/", Typeflag: tar.TypeDir, Mode: 0o755},
+				link("f", "g/.."), link("g", "."), file("f/escaped"),
+			},
+			wantErr: true,
+			outside: "escaped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "workspace")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			err := extract(archive(t, tt.entries...), dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extract() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.outside != "" {
+				if _, err := os.Lstat(filepath.Join(parent, tt.outside)); err == nil {
+					t.Fatalf("%s was written outside the workspace", tt.outside)
+				}
+			}
+		})
+	}
+}
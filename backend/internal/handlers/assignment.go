@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Mond1c/gitea-classroom/config"
 	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/grading"
 	"github.com/Mond1c/gitea-classroom/internal/models"
 	"github.com/Mond1c/gitea-classroom/internal/services"
 	"github.com/labstack/echo/v4"
@@ -26,6 +31,97 @@ type CreateAssignmentRequest struct {
 	Deadline     string `json:"deadline" validate:"required"`
 	MaxPoints    int    `json:"max_points"`
 	AcademicYear int    `json:"academic_year" validate:"required"`
+
+	GradingConfig
+}
+
+// GradingConfig is the autograding part of assignment requests, unset
+// fields are left unchanged on update.
+type GradingConfig struct {
+	GradingCommand     *string `json:"grading_command"`
+	GradingTimeLimit   *int    `json:"grading_time_limit"`
+	GradingMemoryLimit *int    `json:"grading_memory_limit"`
+	GradeOnPush        *bool   `json:"grade_on_push"`
+	GradeAtDeadline    *bool   `json:"grade_at_deadline"`
+	TestsRepo          *string `json:"tests_repo"`
+	TestsRef           *string `json:"tests_ref"`
+
+	GradingResultFormat *string `json:"grading_result_format"`
+	GradingResultPath   *string `json:"grading_result_path"`
+}
+
+// apply validates the config and sets it on the assignment.
+func (g GradingConfig) apply(cfg *config.Config, course *models.Course, assignment *models.Assignment) error {
+	if g.GradingTimeLimit != nil {
+		if *g.GradingTimeLimit < 0 || time.Duration(*g.GradingTimeLimit)*time.Second > grading.MaxTimeLimit {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("grading_time_limit must be between 0 and %d seconds", int(grading.MaxTimeLimit.Seconds())))
+		}
+		assignment.GradingTimeLimit = *g.GradingTimeLimit
+	}
+	if g.GradingMemoryLimit != nil {
+		if *g.GradingMemoryLimit < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "grading_memory_limit must not be negative")
+		}
+		assignment.GradingMemoryLimit = *g.GradingMemoryLimit
+	}
+	if g.GradingCommand != nil {
+		assignment.GradingCommand = strings.TrimSpace(*g.GradingCommand)
+	}
+	if g.GradeOnPush != nil {
+		assignment.GradeOnPush = *g.GradeOnPush
+	}
+	if g.GradeAtDeadline != nil {
+		assignment.GradeAtDeadline = *g.GradeAtDeadline
+	}
+	if g.GradingResultFormat != nil {
+		format := strings.TrimSpace(*g.GradingResultFormat)
+		if format != "" && !grading.IsResultFormat(format) {
+			return echo.NewHTTPError(http.StatusBadRequest, "unknown grading result format")
+		}
+		assignment.GradingResultFormat = format
+	}
+	if g.GradingResultPath != nil {
+		path := strings.TrimSpace(*g.GradingResultPath)
+		if filepath.IsAbs(path) || strings.Contains(path, "..") {
+			return echo.NewHTTPError(http.StatusBadRequest, "grading_result_path must be relative to the repository")
+		}
+		assignment.GradingResultPath = path
+	}
+	if g.TestsRef != nil {
+		assignment.TestsRef = strings.TrimSpace(*g.TestsRef)
+	}
+	if g.TestsRepo != nil {
+		repo := strings.TrimSpace(*g.TestsRepo)
+		if repo != "" && repo != assignment.TestsRepo {
+			if err := checkTestsRepo(cfg, course.OrgName, repo); err != nil {
+				return err
+			}
+		}
+		assignment.TestsRepo = repo
+	}
+	return nil
+}
+
+// checkTestsRepo makes sure the hidden tests repository exists in the course
+// organization and students cannot read it.
+func checkTestsRepo(cfg *config.Config, orgName, repoName string) error {
+	if cfg.GiteaAdminToken == "" {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Gitea admin token not configured")
+	}
+	giteaService, err := services.NewGiteaService(cfg.GiteaURL, cfg.GiteaAdminToken)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize gitea service")
+	}
+
+	repo, err := giteaService.GetRepository(orgName, repoName)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("tests repository %s/%s not found", orgName, repoName))
+	}
+	if !repo.Private {
+		return echo.NewHTTPError(http.StatusBadRequest, "tests repository must be private")
+	}
+	return nil
 }
 
 func (h *AssignmentHandler) Create(c echo.Context) error {
@@ -81,6 +177,9 @@ func (h *AssignmentHandler) Create(c echo.Context) error {
 		}
 		assignment.Deadline = deadline
 	}
+	if err := req.GradingConfig.apply(h.cfg, &course, &assignment); err != nil {
+		return err
+	}
 
 	if err := database.DB.Create(&assignment).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create assignment")
@@ -121,6 +220,8 @@ type UpdateAssignmentRequest struct {
 	Deadline     string `json:"deadline"`
 	MaxPoints    int    `json:"max_points"`
 	AcademicYear int    `json:"academic_year"`
+
+	GradingConfig
 }
 
 func (h *AssignmentHandler) Update(c echo.Context) error {
@@ -131,7 +232,7 @@ func (h *AssignmentHandler) Update(c echo.Context) error {
 	}
 
 	var assignment models.Assignment
-	if err := database.DB.First(&assignment, id).Error; err != nil {
+	if err := database.DB.Preload("Course").First(&assignment, id).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "assignment not found")
 	}
 
@@ -154,23 +255,53 @@ func (h *AssignmentHandler) Update(c echo.Context) error {
 		assignment.TemplateRepo = req.TemplateRepo
 	}
 	if req.MaxPoints > 0 {
+		var rubricPoints int
+		err := database.DB.Model(&models.RubricCriterion{}).
+			Joins("JOIN rubrics ON rubrics.id = rubric_criterions.rubric_id").
+			Where("rubrics.assignment_id = ?", assignment.ID).
+			Select("COALESCE(SUM(rubric_criterions.max_points), 0)").Scan(&rubricPoints).Error
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch rubric")
+		}
+		if req.MaxPoints < rubricPoints {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("max_points is below the %d points of the rubric", rubricPoints))
+		}
 		assignment.MaxPoints = req.MaxPoints
 	}
 	if req.AcademicYear > 0 {
 		assignment.AcademicYear = req.AcademicYear
 	}
+	reopened := false
 	if req.Deadline != "" {
 		deadline, err := parseDateTime(req.Deadline)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "invalid deadline format")
 		}
+		// A moved deadline is handled again when it passes
+		if !deadline.Equal(assignment.Deadline) && deadline.After(time.Now()) {
+			reopened = assignment.DeadlineProcessedAt != nil
+			assignment.DeadlineProcessedAt = nil
+		}
 		assignment.Deadline = deadline
 	}
+	if err := req.GradingConfig.apply(h.cfg, &assignment.Course, &assignment); err != nil {
+		return err
+	}
 
-	if err := database.DB.Save(&assignment).Error; err != nil {
+	if err := database.DB.Omit("Course").Save(&assignment).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update assignment")
 	}
 
+	// Snapshots of the old deadline are taken again at the new one
+	if reopened {
+		err := database.DB.Model(&models.Submission{}).Where("assignment_id = ?", assignment.ID).
+			Updates(map[string]interface{}{"deadline_sha": "", "deadline_tagged_at": nil}).Error
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to reset deadline snapshots")
+		}
+	}
+
 	return c.JSON(http.StatusOK, assignment)
 }
 
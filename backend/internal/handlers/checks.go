@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+)
+
+type GiteaStatusPayload struct {
+	SHA         string    `json:"sha"`
+	State       string    `json:"state"`
+	Context     string    `json:"context"`
+	Description string    `json:"description"`
+	TargetURL   string    `json:"target_url"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Repository  struct {
+		ID      int64  `json:"id"`
+		HTMLURL string `json:"html_url"`
+	} `json:"repository"`
+}
+
+type GiteaWorkflowRunPayload struct {
+	Action   string `json:"action"`
+	Workflow struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+	} `json:"workflow"`
+	WorkflowRun struct {
+		ID           int64     `json:"id"`
+		DisplayTitle string    `json:"display_title"`
+		HeadSHA      string    `json:"head_sha"`
+		Status       string    `json:"status"`
+		Conclusion   string    `json:"conclusion"`
+		HTMLURL      string    `json:"html_url"`
+		UpdatedAt    time.Time `json:"updated_at"`
+	} `json:"workflow_run"`
+	Repository struct {
+		ID      int64  `json:"id"`
+		HTMLURL string `json:"html_url"`
+	} `json:"repository"`
+}
+
+// Handle status events, reported for every CI context of a commit
+func (h *WebhookHandler) handleStatus(body []byte, receivedAt time.Time) (webhookResult, error) {
+	var payload GiteaStatusPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+	}
+	if payload.SHA == "" || payload.Context == "" {
+		return webhookStatus("ignored"), nil
+	}
+
+	state := payload.State
+	switch state {
+	case models.CheckStatePending, models.CheckStateSuccess, models.CheckStateWarning,
+		models.CheckStateFailure, models.CheckStateError:
+	default:
+		return webhookStatus("ignored"), nil
+	}
+
+	return h.recordCheck(payload.Repository.ID, payload.Repository.HTMLURL, models.CommitCheck{
+		SHA:         payload.SHA,
+		Context:     payload.Context,
+		Source:      "status",
+		State:       state,
+		Description: payload.Description,
+		TargetURL:   payload.TargetURL,
+		ReportedAt:  reportedAt(payload.UpdatedAt, receivedAt),
+	})
+}
+
+// Handle workflow_run events of Gitea Actions
+func (h *WebhookHandler) handleWorkflowRun(body []byte, receivedAt time.Time) (webhookResult, error) {
+	var payload GiteaWorkflowRunPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+	}
+	run := payload.WorkflowRun
+	if run.HeadSHA == "" {
+		return webhookStatus("ignored"), nil
+	}
+
+	name := payload.Workflow.Name
+	if name == "" {
+		name = payload.Workflow.Path
+	}
+
+	return h.recordCheck(payload.Repository.ID, payload.Repository.HTMLURL, models.CommitCheck{
+		SHA:         run.HeadSHA,
+		Context:     "workflow: " + name,
+		Source:      "workflow_run",
+		State:       workflowRunState(run.Status, run.Conclusion),
+		Description: run.DisplayTitle,
+		TargetURL:   run.HTMLURL,
+		ReportedAt:  reportedAt(run.UpdatedAt, receivedAt),
+	})
+}
+
+// workflowRunState maps the status and conclusion of a run to a check state.
+func workflowRunState(status, conclusion string) string {
+	if status != "completed" {
+		return models.CheckStatePending
+	}
+	switch conclusion {
+	case "success", "skipped", "neutral":
+		return models.CheckStateSuccess
+	case "cancelled":
+		return models.CheckStateError
+	}
+	return models.CheckStateFailure
+}
+
+func reportedAt(updatedAt, receivedAt time.Time) time.Time {
+	if updatedAt.IsZero() {
+		return receivedAt
+	}
+	return updatedAt
+}
+
+// recordCheck stores the check on the submission of the repository and
+// refreshes its CI state.
+func (h *WebhookHandler) recordCheck(repoID int64, repoURL string, check models.CommitCheck) (webhookResult, error) {
+	submission, found := findSubmission(repoID, repoURL)
+	if !found {
+		return webhookStatus("submission_not_found"), nil
+	}
+	check.SubmissionID = submission.ID
+
+	// Deliveries may be handled out of order, older reports never win
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "submission_id"}, {Name: "sha"}, {Name: "context"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "source", "state", "description", "target_url", "reported_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "commit_checks.reported_at <= excluded.reported_at"},
+		}},
+	}).Create(&check).Error
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to store check")
+	}
+
+	state, err := refreshCIState(submission.ID)
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to update CI state")
+	}
+
+	log.Printf("Check %q of submission %d at %s: %s", check.Context, submission.ID, check.SHA, check.State)
+
+	return webhookStatus("check_recorded").
+		with("submission_id", submission.ID).
+		with("context", check.Context).
+		with("ci_state", state), nil
+}
+
+// refreshCIState recomputes the combined check state of the submission head
+// commit, or of the latest checked commit while the head is unknown.
+func refreshCIState(submissionID uint) (string, error) {
+	var submission models.Submission
+	if err := database.DB.Select("id", "head_sha").First(&submission, submissionID).Error; err != nil {
+		return "", err
+	}
+
+	sha := submission.HeadSHA
+	if sha == "" {
+		var latest models.CommitCheck
+		err := database.DB.Where("submission_id = ?", submissionID).
+			Order("reported_at DESC").Limit(1).Find(&latest).Error
+		if err != nil {
+			return "", err
+		}
+		sha = latest.SHA
+	}
+
+	var checks []models.CommitCheck
+	if sha != "" {
+		if err := database.DB.Where("submission_id = ? AND sha = ?", submissionID, sha).Find(&checks).Error; err != nil {
+			return "", err
+		}
+	}
+	state := combinedState(checks)
+
+	err := database.DB.Model(&models.Submission{}).Where("id = ?", submissionID).
+		Updates(map[string]interface{}{"ci_state": state, "ci_sha": sha}).Error
+	return state, err
+}
+
+// combinedState is failure when any check failed, pending while any check
+// runs and success otherwise. It is empty without checks.
+func combinedState(checks []models.CommitCheck) string {
+	if len(checks) == 0 {
+		return ""
+	}
+	state := models.CheckStateSuccess
+	for _, check := range checks {
+		switch check.State {
+		case models.CheckStateFailure, models.CheckStateError:
+			return models.CheckStateFailure
+		case models.CheckStatePending:
+			state = models.CheckStatePending
+		}
+	}
+	return state
+}
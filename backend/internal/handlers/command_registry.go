@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+)
+
+// commandPermission is who may run a Feedback PR command
+type commandPermission int
+
+const (
+	// permOwner allows the student owning the submission
+	permOwner commandPermission = iota
+	// permTeam allows anyone with write access to the repository
+	permTeam
+	// permInstructor allows course instructors and LMS admins
+	permInstructor
+	// permAdmin allows LMS admins
+	permAdmin
+)
+
+func (p commandPermission) String() string {
+	switch p {
+	case permOwner:
+		return "submission owner"
+	case permTeam:
+		return "repository members"
+	case permInstructor:
+		return "instructors"
+	case permAdmin:
+		return "admins"
+	}
+	return "unknown"
+}
+
+// commandContext is a parsed command comment with its submission. user is
+// the LMS account of the commenter, loaded for instructor and admin commands.
+type commandContext struct {
+	payload    GiteaIssueCommentPayload
+	submission models.Submission
+	commenter  string
+	user       *models.User
+	args       string
+}
+
+// slashCommand is a Feedback PR comment command. Name is written after a
+// slash, aliases are complete words such as "@review".
+type slashCommand struct {
+	Name        string
+	Aliases     []string
+	Usage       string
+	Description string
+	Permission  commandPermission
+	Run         func(h *WebhookHandler, cmd *commandContext) (webhookResult, error)
+}
+
+// commandRegistry holds the slash commands by name and alias
+type commandRegistry struct {
+	commands []*slashCommand
+	byToken  map[string]*slashCommand
+}
+
+func (r *commandRegistry) register(command *slashCommand) {
+	r.commands = append(r.commands, command)
+	r.byToken["/"+command.Name] = command
+	for _, alias := range command.Aliases {
+		r.byToken[alias] = command
+	}
+}
+
+// commands are the commands understood on Feedback PRs
+var commands = &commandRegistry{byToken: make(map[string]*slashCommand)}
+
+func init() {
+	commands.register(&slashCommand{
+		Name:        "review",
+		Aliases:     []string{"@review"},
+		Usage:       "/review",
+		Description: "request a review, it can be cancelled for a few minutes",
+		Permission:  permOwner,
+		Run:         (*WebhookHandler).handleReviewCommand,
+	})
+	commands.register(&slashCommand{
+		Name:        "unreview",
+		Usage:       "/unreview",
+		Description: "cancel a review request while it is still pending",
+		Permission:  permOwner,
+		Run:         (*WebhookHandler).handleUnreviewCommand,
+	})
+	commands.register(&slashCommand{
+		Name:        "review_now",
+		Usage:       "/review_now",
+		Description: "request a review and send it to instructors right away",
+		Permission:  permOwner,
+		Run:         (*WebhookHandler).handleReviewNowCommand,
+	})
+	commands.register(&slashCommand{
+		Name:        "claim",
+		Usage:       "/claim",
+		Description: "take over the active review request",
+		Permission:  permInstructor,
+		Run:         (*WebhookHandler).handleClaimCommand,
+	})
+	commands.register(&slashCommand{
+		Name:        "grade",
+		Usage:       "/grade <score> [feedback]",
+		Description: "grade the submission and finish the review",
+		Permission:  permInstructor,
+		Run:         (*WebhookHandler).handleGradeCommand,
+	})
+	commands.register(&slashCommand{
+		Name:        "force_unreview",
+		Usage:       "/force_unreview",
+		Description: "cancel any active review request",
+		Permission:  permAdmin,
+		Run:         (*WebhookHandler).handleForceUnreviewCommand,
+	})
+	commands.register(&slashCommand{
+		Name:        "help",
+		Usage:       "/help",
+		Description: "list the available commands",
+		Permission:  permTeam,
+		Run:         (*WebhookHandler).handleHelpCommand,
+	})
+}
+
+// isKnownCommand reports whether name is a registered command name.
+func isKnownCommand(name string) bool {
+	_, ok := commands.byToken["/"+name]
+	return ok
+}
+
+// parse finds the first known command in a comment. Commands start a line,
+// quoted lines and code blocks are skipped. The arguments are the rest of
+// the line and the lines after it.
+func (r *commandRegistry) parse(body string) (*slashCommand, string, string) {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	inCode := false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || line == "" || strings.HasPrefix(line, ">") {
+			continue
+		}
+
+		token := line
+		if end := strings.IndexAny(line, " \t"); end >= 0 {
+			token = line[:end]
+		}
+		command, ok := r.byToken[strings.ToLower(token)]
+		if !ok {
+			continue
+		}
+
+		rest := append([]string{strings.TrimPrefix(line, token)}, lines[i+1:]...)
+		return command, token, strings.TrimSpace(strings.Join(rest, "\n"))
+	}
+	return nil, "", ""
+}
+
+// runCommand checks that the command is enabled in the course and allowed
+// for the commenter before running it.
+func (h *WebhookHandler) runCommand(command *slashCommand, cmd *commandContext) (webhookResult, error) {
+	if courseDisablesCommand(&cmd.submission.Assignment.Course, command.Name) {
+		return webhookStatus("command_disabled"), nil
+	}
+	if denied, ok := h.authorizeCommand(command.Permission, cmd); !ok {
+		return denied, nil
+	}
+	return command.Run(h, cmd)
+}
+
+// authorizeCommand returns the reply status when the commenter lacks the
+// permission.
+func (h *WebhookHandler) authorizeCommand(permission commandPermission, cmd *commandContext) (webhookResult, bool) {
+	isOwner := cmd.submission.Student.Username == cmd.commenter
+
+	switch permission {
+	case permOwner:
+		if !isOwner {
+			return webhookStatus("not_submission_owner"), false
+		}
+		return webhookResult{}, true
+
+	case permTeam:
+		if isOwner || h.hasWriteAccess(cmd) {
+			return webhookResult{}, true
+		}
+		return webhookStatus("not_team_member"), false
+	}
+
+	var user models.User
+	if err := database.DB.Where("username = ?", cmd.commenter).First(&user).Error; err != nil {
+		return webhookStatus("commenter_not_found"), false
+	}
+	cmd.user = &user
+
+	if permission == permInstructor && (user.IsAdmin || isInstructor(user.ID, cmd.submission.Assignment.CourseID)) {
+		return webhookResult{}, true
+	}
+	if permission == permAdmin && user.IsAdmin {
+		return webhookResult{}, true
+	}
+	if permission == permAdmin {
+		return webhookStatus("admin_only"), false
+	}
+	return webhookStatus("forbidden"), false
+}
+
+// hasWriteAccess reports whether the commenter can push to the submission
+// repository, through collaboration or a team.
+func (h *WebhookHandler) hasWriteAccess(cmd *commandContext) bool {
+	if h.cfg.GiteaAdminToken == "" {
+		return false
+	}
+	giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, h.cfg.GiteaAdminToken)
+	if err != nil {
+		return false
+	}
+
+	orgName := cmd.submission.Assignment.Course.OrgName
+	repoName := review.RepoNameFromURL(cmd.submission.RepoURL)
+	mode, err := giteaService.GetCollaboratorPermission(orgName, repoName, cmd.commenter)
+	if err != nil {
+		log.Printf("Warning: failed to check access of %s to %s/%s: %v", cmd.commenter, orgName, repoName, err)
+		return false
+	}
+	return mode == gitea.AccessModeWrite || mode == gitea.AccessModeAdmin || mode == gitea.AccessModeOwner
+}
+
+// courseDisablesCommand reports whether the course turned the command off.
+func courseDisablesCommand(course *models.Course, name string) bool {
+	for _, disabled := range strings.Split(course.DisabledCommands, ",") {
+		if strings.TrimSpace(disabled) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle /help command - list the commands enabled in the course
+func (h *WebhookHandler) handleHelpCommand(cmd *commandContext) (webhookResult, error) {
+	course := &cmd.submission.Assignment.Course
+
+	enabled := make([]*slashCommand, 0, len(commands.commands))
+	for _, command := range commands.commands {
+		if !courseDisablesCommand(course, command.Name) {
+			enabled = append(enabled, command)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		return enabled[i].Permission < enabled[j].Permission
+	})
+
+	var lines []string
+	for _, command := range enabled {
+		lines = append(lines, fmt.Sprintf("- `%s` %s (%s)", command.Usage, command.Description, command.Permission))
+	}
+
+	return webhookStatus("help").with("commands", strings.Join(lines, "\n")), nil
+}
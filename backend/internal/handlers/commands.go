@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/labstack/echo/v4"
+)
+
+// webhookResult is the outcome of handling a webhook. Status is returned to
+// Gitea, stored with the delivery and, for comment commands, selects the bot
+// reply posted on the pull request. Data is added to all of them.
+type webhookResult struct {
+	Status string
+	Data   map[string]interface{}
+}
+
+func webhookStatus(status string) webhookResult {
+	return webhookResult{Status: status, Data: map[string]interface{}{}}
+}
+
+func (r webhookResult) with(key string, value interface{}) webhookResult {
+	r.Data[key] = value
+	return r
+}
+
+func (r webhookResult) response() map[string]interface{} {
+	resp := map[string]interface{}{"status": r.Status}
+	for k, v := range r.Data {
+		resp[k] = v
+	}
+	return resp
+}
+
+// Handle issue_comment events, slash commands on the Feedback PR
+func (h *WebhookHandler) handleIssueComment(body []byte) (webhookResult, error) {
+	var payload GiteaIssueCommentPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+	}
+
+	// Only process created comments
+	if payload.Action != "created" {
+		return webhookStatus("ignored"), nil
+	}
+
+	// Only process comments on pull requests
+	if !payload.IsPull {
+		return webhookStatus("not_pull_request"), nil
+	}
+
+	// The bot replies quote commands, never react to them
+	if h.bot.IsSelf(commentAuthor(payload)) {
+		return webhookStatus("bot_comment"), nil
+	}
+
+	// Only process the Feedback PR
+	submission, found := findSubmission(payload.Repository.ID, payload.Repository.HTMLURL)
+	if !isFeedbackPR(submission, found, payload.Issue.Number, payload.Issue.Title) {
+		return webhookStatus("not_feedback_pr"), nil
+	}
+	if found {
+		rememberFeedbackPR(&submission, payload.Issue.Number)
+	}
+
+	command, token, args := commands.parse(payload.Comment.Body)
+	if command == nil {
+		return webhookStatus("not_review_command"), nil
+	}
+
+	result := webhookStatus("submission_not_found")
+	if found {
+		cmd := &commandContext{
+			payload:    payload,
+			submission: submission,
+			commenter:  commentAuthor(payload),
+			args:       args,
+		}
+		var err error
+		if result, err = h.runCommand(command, cmd); err != nil {
+			return webhookResult{}, err
+		}
+	}
+
+	h.replyToCommand(payload, token, result)
+
+	return result, nil
+}
+
+// replyToCommand posts the bot message for the command outcome on the PR,
+// so the commenter can see what happened.
+func (h *WebhookHandler) replyToCommand(payload GiteaIssueCommentPayload, command string, result webhookResult) {
+	data := map[string]interface{}{
+		"user":    commentAuthor(payload),
+		"command": command,
+	}
+	for k, v := range result.Data {
+		data[k] = v
+	}
+
+	err := h.bot.Post(payload.Repository.Owner.Login, payload.Repository.Name, payload.Issue.Number, result.Status, data)
+	if err != nil {
+		log.Printf("Warning: failed to reply to %s on %s#%d: %v", command, payload.Repository.FullName, payload.Issue.Number, err)
+	}
+}
+
+func commentAuthor(payload GiteaIssueCommentPayload) string {
+	if payload.Comment.User.Username != "" {
+		return payload.Comment.User.Username
+	}
+	return payload.Comment.User.Login
+}
+
+// Handle /review or @review command
+func (h *WebhookHandler) handleReviewCommand(cmd *commandContext) (webhookResult, error) {
+	submission := cmd.submission
+
+	// Check for existing active review request
+	var existingRequest models.ReviewRequest
+	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
+		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).First(&existingRequest).Error
+	if err == nil {
+		return webhookStatus("review_already_active").with("review_request_id", existingRequest.ID), nil
+	}
+
+	// Create review request
+	reviewRequest, err := h.reviews.Request(submission, cmd.commenter, "/review comment")
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to create review request")
+	}
+
+	log.Printf("Review request created: ID=%d, Submission=%d, TTL=%v minutes, Expires at=%v\n",
+		reviewRequest.ID, submission.ID, h.cfg.ReviewPendingMinutes, reviewRequest.CancelDeadline)
+
+	return webhookStatus("review_requested").
+		with("review_request_id", reviewRequest.ID).
+		with("cancel_deadline", *reviewRequest.CancelDeadline).
+		with("cancel_minutes", h.cfg.ReviewPendingMinutes), nil
+}
+
+// Handle /unreview command - cancel review while it's inside the cancel window
+func (h *WebhookHandler) handleUnreviewCommand(cmd *commandContext) (webhookResult, error) {
+	submission := cmd.submission
+
+	// Find pending review request
+	var reviewRequest models.ReviewRequest
+	err := database.DB.Where("submission_id = ? AND status = ?", submission.ID, models.ReviewStatusPending).
+		First(&reviewRequest).Error
+	if err != nil {
+		return webhookStatus("no_pending_review"), nil
+	}
+
+	// Update review request status, unless the cancel period is over. The
+	// state machine restores write access.
+	reviewRequest.Submission = submission
+	err = h.reviews.Apply(&reviewRequest, review.Transition{
+		To:                 models.ReviewStatusCancelled,
+		Actor:              cmd.commenter,
+		Reason:             "/unreview comment",
+		WithinCancelWindow: true,
+	})
+	if errors.Is(err, review.ErrConflict) {
+		return webhookStatus("review_already_submitted").
+			with("message", "Review has already been submitted and cannot be cancelled"), nil
+	}
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to cancel review request")
+	}
+
+	log.Printf("Review request cancelled: ID=%d, Submission=%d", reviewRequest.ID, submission.ID)
+
+	return webhookStatus("review_cancelled").
+		with("message", "Review request cancelled, write access restored"), nil
+}
+
+// Handle /review_now command - immediately submit to the review sinks
+func (h *WebhookHandler) handleReviewNowCommand(cmd *commandContext) (webhookResult, error) {
+	submission := cmd.submission
+
+	// Check for existing active review request
+	var existingRequest models.ReviewRequest
+	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
+		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).First(&existingRequest).Error
+	if err == nil {
+		return webhookStatus("review_already_active").with("review_request_id", existingRequest.ID), nil
+	}
+
+	// Create review request and immediately submit it
+	reviewRequest, err := h.reviews.Request(submission, cmd.commenter, "/review_now comment")
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to create review request")
+	}
+
+	if err := h.reviews.SubmitNow(reviewRequest, cmd.commenter, "/review_now comment"); err != nil {
+		log.Printf("Warning: failed to submit review request %d immediately, the review worker retries: %v", reviewRequest.ID, err)
+	} else {
+		log.Printf("Review request immediately submitted: ID=%d, Submission=%d", reviewRequest.ID, submission.ID)
+	}
+
+	return webhookStatus("review_submitted_immediately").
+		with("review_request_id", reviewRequest.ID).
+		with("message", "Review request submitted immediately"), nil
+}
+
+// Handle /force_unreview command - admin command to cancel any review request
+func (h *WebhookHandler) handleForceUnreviewCommand(cmd *commandContext) (webhookResult, error) {
+	submission := cmd.submission
+
+	commenterUsername := cmd.commenter
+
+	// Find the active review request, reviewed requests are final
+	var reviewRequest models.ReviewRequest
+	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
+		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).
+		Order("created_at DESC").First(&reviewRequest).Error
+	if err != nil {
+		return webhookStatus("no_review_request"), nil
+	}
+
+	// Update review request status, the state machine restores write access
+	// and removes the request from the review sinks
+	previousStatus := reviewRequest.Status
+	reviewRequest.Submission = submission
+	err = h.reviews.Apply(&reviewRequest, review.Transition{
+		To:     models.ReviewStatusCancelled,
+		Actor:  commenterUsername,
+		Reason: "/force_unreview comment",
+	})
+	if errors.Is(err, review.ErrConflict) {
+		return webhookStatus("no_review_request"), nil
+	}
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to cancel review request")
+	}
+
+	log.Printf("Review request force-cancelled by admin %s: ID=%d, Submission=%d, Status was=%s",
+		commenterUsername, reviewRequest.ID, submission.ID, previousStatus)
+
+	return webhookStatus("force_cancelled").
+		with("message", fmt.Sprintf("Review request cancelled by admin %s, write access restored", commenterUsername)), nil
+}
+
+// Handle /grade <score> [feedback] command - instructor grades the submission
+// and completes the active review request
+func (h *WebhookHandler) handleGradeCommand(cmd *commandContext) (webhookResult, error) {
+	submission := cmd.submission
+
+	commenterUsername := cmd.commenter
+
+	score, feedback, err := parseGradeArgs(cmd.args)
+	if err != nil {
+		return webhookStatus("invalid_grade").with("message", err.Error()), nil
+	}
+
+	if score < 0 || score > submission.Assignment.MaxPoints {
+		return webhookStatus("invalid_grade").
+			with("message", fmt.Sprintf("score must be between 0 and %d", submission.Assignment.MaxPoints)), nil
+	}
+
+	if err := applyGrade(&submission, score, feedback, nil); err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to grade submission")
+	}
+
+	result := webhookStatus("graded").
+		with("score", score).
+		with("max_points", submission.Assignment.MaxPoints)
+
+	// Grading finishes the review round as well
+	var reviewRequest models.ReviewRequest
+	err = database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
+		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).
+		Order("created_at DESC").First(&reviewRequest).Error
+	if err == nil {
+		outcome := models.ReviewEvent{Actor: commenterUsername, Reason: "graded with /grade", Body: feedback}
+		if err := h.completeReview(&reviewRequest, submission, outcome); err != nil {
+			return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to update review request")
+		}
+		result = result.with("review_request_id", reviewRequest.ID)
+	}
+
+	log.Printf("Submission %d graded via /grade by %s: %d/%d", submission.ID, commenterUsername, score, submission.Assignment.MaxPoints)
+
+	return result, nil
+}
+
+// parseGradeArgs splits "<score> [feedback]", the feedback keeps its line breaks.
+func parseGradeArgs(args string) (int, string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return 0, "", fmt.Errorf("usage: /grade <score> [feedback]")
+	}
+
+	score, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid score %q", fields[0])
+	}
+
+	feedback := strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+	return score, feedback, nil
+}
+
+// Handle /claim command - instructor takes over the active review request
+func (h *WebhookHandler) handleClaimCommand(cmd *commandContext) (webhookResult, error) {
+	submission := cmd.submission
+
+	var reviewRequest models.ReviewRequest
+	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
+		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).
+		Order("created_at DESC").First(&reviewRequest).Error
+	if err != nil {
+		return webhookStatus("no_active_review"), nil
+	}
+
+	reviewRequest.Submission = submission
+	if err := h.assigner.Assign(&reviewRequest, cmd.user); err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to assign reviewer")
+	}
+
+	return webhookStatus("claimed").
+		with("review_request_id", reviewRequest.ID).
+		with("reviewer", cmd.user.Username), nil
+}
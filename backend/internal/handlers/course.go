@@ -3,22 +3,28 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/Mond1c/gitea-classroom/config"
 	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/hooks"
 	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/Mond1c/gitea-classroom/internal/sinks"
 	"github.com/labstack/echo/v4"
 )
 
 type CourseHandler struct {
-	cfg *config.Config
+	cfg   *config.Config
+	hooks *hooks.Manager
 }
 
-func NewCourseHandler(cfg *config.Config) *CourseHandler {
-	return &CourseHandler{cfg: cfg}
+func NewCourseHandler(cfg *config.Config, hookManager *hooks.Manager) *CourseHandler {
+	return &CourseHandler{cfg: cfg, hooks: hookManager}
 }
 
 type CreateCourseRequest struct {
@@ -65,6 +71,14 @@ func (h *CourseHandler) Create(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create course")
 	}
 
+	// One organization hook covers every submission repository, the hook
+	// worker or RepairWebhooks retry when this fails
+	if h.hooks.Enabled() {
+		if _, err := h.hooks.EnsureOrgHook(course.OrgName); err != nil {
+			log.Printf("Warning: failed to register webhook on organization %s: %v", course.OrgName, err)
+		}
+	}
+
 	return c.JSON(http.StatusCreated, course)
 }
 
@@ -139,6 +153,105 @@ func (h *CourseHandler) Get(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+type UpdateCourseRequest struct {
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	ReviewSinks        []string `json:"review_sinks"`
+	ReviewerAssignment string   `json:"reviewer_assignment"`
+	RepoLockStrategy   string   `json:"repo_lock_strategy"`
+	ReviewSLADays      *int     `json:"review_sla_days"`
+	DisabledCommands   []string `json:"disabled_commands"`
+}
+
+func (h *CourseHandler) Update(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	slug := c.Param("slug")
+
+	var course models.Course
+	if err := database.DB.Where("slug = ?", slug).First(&course).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "course not found")
+	}
+
+	if !isInstructor(userID, course.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, "only instructors can update courses")
+	}
+
+	var req UpdateCourseRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if req.Name != "" {
+		course.Name = req.Name
+	}
+	if req.Description != "" {
+		course.Description = req.Description
+	}
+	if req.ReviewSinks != nil {
+		for _, name := range req.ReviewSinks {
+			if !sinks.IsKnown(name) {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown review sink %q", name))
+			}
+		}
+		course.ReviewSinks = strings.Join(req.ReviewSinks, ",")
+	}
+	if req.ReviewerAssignment != "" {
+		if !review.IsAssignmentStrategy(req.ReviewerAssignment) {
+			return echo.NewHTTPError(http.StatusBadRequest, "unknown reviewer assignment strategy")
+		}
+		course.ReviewerAssignment = req.ReviewerAssignment
+	}
+	if req.RepoLockStrategy != "" {
+		if !review.IsLockStrategy(req.RepoLockStrategy) {
+			return echo.NewHTTPError(http.StatusBadRequest, "unknown repository lock strategy")
+		}
+		course.RepoLockStrategy = req.RepoLockStrategy
+	}
+	if req.ReviewSLADays != nil {
+		course.ReviewSLADays = *req.ReviewSLADays
+	}
+	if req.DisabledCommands != nil {
+		for _, name := range req.DisabledCommands {
+			if !isKnownCommand(name) {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown command %q", name))
+			}
+		}
+		course.DisabledCommands = strings.Join(req.DisabledCommands, ",")
+	}
+
+	if err := database.DB.Save(&course).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update course")
+	}
+
+	return c.JSON(http.StatusOK, course)
+}
+
+// RepairWebhooks registers the organization webhook of the course and
+// reconciles the hooks of its submission repositories.
+func (h *CourseHandler) RepairWebhooks(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	slug := c.Param("slug")
+
+	var course models.Course
+	if err := database.DB.Where("slug = ?", slug).First(&course).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "course not found")
+	}
+
+	if !isInstructor(userID, course.ID) && !isAdmin(userID) {
+		return echo.NewHTTPError(http.StatusForbidden, "only instructors can repair webhooks")
+	}
+
+	report, err := h.hooks.ReconcileCourse(&course)
+	if errors.Is(err, hooks.ErrDisabled) {
+		return echo.NewHTTPError(http.StatusBadRequest, "webhooks are not configured")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to repair webhooks: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
 func (h *CourseHandler) GetByInviteCode(c echo.Context) error {
 	code := c.Param("code")
 
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"net/http"
+	"strconv"
+
 	"github.com/Mond1c/gitea-classroom/internal/database"
 	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/labstack/echo/v4"
 )
 
 func isInstructor(userID uint, courseID uint) bool {
@@ -33,3 +37,36 @@ func isStudentOfCourse(userID uint, courseID uint) bool {
 		Count(&count)
 	return count > 0
 }
+
+func isAdmin(userID uint) bool {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.IsAdmin
+}
+
+// loadAccessibleSubmission loads the submission with Student and Assignment
+// if the current user owns it or teaches the course.
+func loadAccessibleSubmission(c echo.Context, idParam string) (*models.Submission, error) {
+	userID := c.Get("user_id").(uint)
+	id, err := strconv.ParseUint(idParam, 10, 32)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid submission id")
+	}
+
+	var submission models.Submission
+	if err := database.DB.Preload("Student").Preload("Assignment").First(&submission, id).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "submission not found")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "user not found")
+	}
+
+	if submission.Student.GiteaID != user.GiteaID && !isInstructor(userID, submission.Assignment.CourseID) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "you don't have access to this submission")
+	}
+	return &submission, nil
+}
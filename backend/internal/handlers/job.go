@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/labstack/echo/v4"
+)
+
+type JobHandler struct {
+	jobs *jobs.Queue
+}
+
+func NewJobHandler(queue *jobs.Queue) *JobHandler {
+	return &JobHandler{jobs: queue}
+}
+
+// List returns background jobs, newest first, e.g. ?state=dead for the
+// dead letters.
+func (h *JobHandler) List(c echo.Context) error {
+	if !isAdmin(c.Get("user_id").(uint)) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can view jobs")
+	}
+
+	query := database.DB.Model(&models.Job{})
+	if state := c.QueryParam("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if kind := c.QueryParam("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	var list []models.Job
+	if err := query.Order("created_at DESC, id DESC").Limit(maxDeliveryLimit).Find(&list).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch jobs")
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// Retry queues a dead job again.
+func (h *JobHandler) Retry(c echo.Context) error {
+	if !isAdmin(c.Get("user_id").(uint)) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can retry jobs")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid job id")
+	}
+
+	if err := h.jobs.Retry(uint(id)); err != nil {
+		if errors.Is(err, jobs.ErrNotDead) {
+			return echo.NewHTTPError(http.StatusConflict, "only dead jobs can be retried")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retry job")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": models.JobStateQueued})
+}
+
+// BackfillSubmissionIDs queues the job recording Gitea repository IDs and
+// Feedback PR numbers on older submissions.
+func (h *JobHandler) BackfillSubmissionIDs(c echo.Context) error {
+	if !isAdmin(c.Get("user_id").(uint)) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can start a backfill")
+	}
+
+	job, err := h.jobs.Enqueue(review.JobKindBackfillIDs, struct{}{})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to queue backfill")
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
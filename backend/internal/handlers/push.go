@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/grading"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+)
+
+// zeroSHA is the "after" commit of a push deleting the branch
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+type GiteaPushPayload struct {
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name     string `json:"name"`
+			Email    string `json:"email"`
+			Username string `json:"username"`
+		} `json:"author"`
+		Timestamp time.Time `json:"timestamp"`
+	} `json:"commits"`
+	Repository struct {
+		ID            int64  `json:"id"`
+		Name          string `json:"name"`
+		FullName      string `json:"full_name"`
+		HTMLURL       string `json:"html_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Pusher struct {
+		Login    string `json:"login"`
+		Username string `json:"username"`
+	} `json:"pusher"`
+}
+
+// Handle push events, the pushed commits are stored for the submission
+func (h *WebhookHandler) handlePush(body []byte, receivedAt time.Time) (webhookResult, error) {
+	var payload GiteaPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+	}
+
+	// Tags carry no new work
+	if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+		return webhookStatus("ignored"), nil
+	}
+	branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+	if payload.After == zeroSHA {
+		return webhookStatus("branch_deleted"), nil
+	}
+
+	submission, found := findSubmission(payload.Repository.ID, payload.Repository.HTMLURL)
+	if !found {
+		return webhookStatus("submission_not_found"), nil
+	}
+
+	pusher := payload.Pusher.Username
+	if pusher == "" {
+		pusher = payload.Pusher.Login
+	}
+
+	commits := make([]models.Commit, 0, len(payload.Commits))
+	for _, pc := range payload.Commits {
+		author := pc.Author.Username
+		if author == "" {
+			author = pc.Author.Name
+		}
+		commits = append(commits, models.Commit{
+			SubmissionID: submission.ID,
+			SHA:          pc.ID,
+			Author:       author,
+			AuthorEmail:  pc.Author.Email,
+			Pusher:       pusher,
+			Timestamp:    pc.Timestamp,
+			Branch:       branch,
+			Message:      pc.Message,
+			URL:          pc.URL,
+			PushedAt:     receivedAt,
+		})
+	}
+	if len(commits) > 0 {
+		// Commits pushed again, e.g. to another branch, keep their first record
+		err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&commits).Error
+		if err != nil {
+			return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to store commits")
+		}
+	}
+
+	// Deliveries may be handled out of order, older pushes never win
+	updates := map[string]interface{}{"last_push_at": receivedAt}
+	if branch == payload.Repository.DefaultBranch {
+		updates["head_sha"] = payload.After
+	}
+	result := database.DB.Model(&models.Submission{}).
+		Where("id = ? AND (last_push_at IS NULL OR last_push_at <= ?)", submission.ID, receivedAt).
+		Updates(updates)
+	if result.Error != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to update submission")
+	}
+
+	// The CI state follows the new head
+	if _, moved := updates["head_sha"]; moved && result.RowsAffected > 0 {
+		if _, err := refreshCIState(submission.ID); err != nil {
+			log.Printf("Warning: failed to refresh CI state of submission %d: %v", submission.ID, err)
+		}
+	}
+
+	if branch == payload.Repository.DefaultBranch && submission.Assignment.GradeOnPush && grading.Enabled(&submission.Assignment) &&
+		grading.SandboxConfigured(h.cfg) {
+		if _, err := grading.Enqueue(h.jobs, &submission, payload.After, models.GradingTriggerPush, nil); err != nil {
+			return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to queue grading run")
+		}
+	}
+
+	log.Printf("Push to %s of submission %d: %d commits by %s, head %s", branch, submission.ID, len(commits), pusher, payload.After)
+
+	return webhookStatus("push_recorded").
+		with("submission_id", submission.ID).
+		with("branch", branch).
+		with("commits", len(commits)), nil
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -8,24 +10,25 @@ import (
 	"time"
 
 	"github.com/Mond1c/gitea-classroom/config"
-	"github.com/Mond1c/gitea-classroom/internal/cache"
 	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/middleware"
 	"github.com/Mond1c/gitea-classroom/internal/models"
-	"github.com/Mond1c/gitea-classroom/internal/services"
+	"github.com/Mond1c/gitea-classroom/internal/pubsub"
+	"github.com/Mond1c/gitea-classroom/internal/review"
 	"github.com/labstack/echo/v4"
 )
 
 type ReviewHandler struct {
-	cfg    *config.Config
-	cache  *cache.ReviewCache
-	sheets *services.SheetsService
+	cfg     *config.Config
+	reviews *review.Machine
+	events  *pubsub.Broker
 }
 
-func NewReviewHandler(cfg *config.Config, reviewCache *cache.ReviewCache, sheets *services.SheetsService) *ReviewHandler {
+func NewReviewHandler(cfg *config.Config, reviews *review.Machine, events *pubsub.Broker) *ReviewHandler {
 	return &ReviewHandler{
-		cfg:    cfg,
-		cache:  reviewCache,
-		sheets: sheets,
+		cfg:     cfg,
+		reviews: reviews,
+		events:  events,
 	}
 }
 
@@ -61,50 +64,16 @@ func (h *ReviewHandler) RequestReview(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusConflict, "active review request already exists")
 	}
 
-	// Get instructor to use their token for Gitea operations
-	var instructor models.User
-	err = database.DB.
-		Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
-		Where("course_instructors.course_id = ?", submission.Assignment.CourseID).
-		First(&instructor).Error
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "no instructor found for course")
-	}
-
-	giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, instructor.AccessToken)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize gitea service")
-	}
-
-	// Extract repo name from URL
-	repoName := extractRepoName(submission.RepoURL)
-	orgName := submission.Assignment.Course.OrgName
-
-	// Enable branch protection to block pushes
-	if err := giteaService.EnableBranchProtection(orgName, repoName, "main"); err != nil {
-		// Log but don't fail - protection might already exist
-		fmt.Printf("Warning: failed to enable branch protection: %v\n", err)
-	}
-
 	// Create review request
-	reviewRequest := models.ReviewRequest{
-		SubmissionID: submission.ID,
-		Status:       models.ReviewStatusPending,
-		RequestedAt:  time.Now(),
-	}
-
-	if err := database.DB.Create(&reviewRequest).Error; err != nil {
+	reviewRequest, err := h.reviews.Request(submission, user.Username, "requested from the LMS")
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create review request")
 	}
 
-	// Add to cache with TTL
-	ttl := time.Duration(h.cfg.ReviewPendingMinutes) * time.Minute
-	h.cache.Add(reviewRequest.ID, submission.ID, ttl)
-
 	return c.JSON(http.StatusCreated, map[string]interface{}{
-		"review_request":   reviewRequest,
-		"cancel_deadline":  reviewRequest.RequestedAt.Add(ttl),
-		"seconds_to_cancel": int(ttl.Seconds()),
+		"review_request":    reviewRequest,
+		"cancel_deadline":   reviewRequest.CancelDeadline,
+		"seconds_to_cancel": int(reviewRequest.CancelTimeRemaining(reviewRequest.RequestedAt).Seconds()),
 	})
 }
 
@@ -135,37 +104,22 @@ func (h *ReviewHandler) CancelReview(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "can only cancel pending review requests")
 	}
 
-	// Check if still in cache (within TTL)
-	if !h.cache.Exists(reviewRequest.ID) {
+	// Check if still within the cancel window
+	if !reviewRequest.CanCancel(time.Now()) {
 		return echo.NewHTTPError(http.StatusBadRequest, "cancellation period has expired")
 	}
 
-	// Get instructor token for Gitea operations
-	var instructor models.User
-	err = database.DB.
-		Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
-		Where("course_instructors.course_id = ?", reviewRequest.Submission.Assignment.CourseID).
-		First(&instructor).Error
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "no instructor found")
+	// Update status, unless the review worker submitted it in the meantime
+	err = h.reviews.Apply(&reviewRequest, review.Transition{
+		To:                 models.ReviewStatusCancelled,
+		Actor:              user.Username,
+		Reason:             "cancelled by student",
+		WithinCancelWindow: true,
+	})
+	if errors.Is(err, review.ErrConflict) {
+		return echo.NewHTTPError(http.StatusBadRequest, "cancellation period has expired")
 	}
-
-	giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, instructor.AccessToken)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize gitea service")
-	}
-
-	// Disable branch protection
-	repoName := extractRepoName(reviewRequest.Submission.RepoURL)
-	orgName := reviewRequest.Submission.Assignment.Course.OrgName
-	giteaService.DisableBranchProtection(orgName, repoName, "main")
-
-	// Remove from cache
-	h.cache.Remove(reviewRequest.ID)
-
-	// Update status
-	reviewRequest.Status = models.ReviewStatusCancelled
-	if err := database.DB.Save(&reviewRequest).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update review request")
 	}
 
@@ -197,7 +151,7 @@ func (h *ReviewHandler) GetReviewStatus(c echo.Context) error {
 
 	// If pending, add time remaining
 	if reviewRequest.Status == models.ReviewStatusPending {
-		remaining := h.cache.GetTimeRemaining(reviewRequest.ID)
+		remaining := reviewRequest.CancelTimeRemaining(time.Now())
 		response["seconds_remaining"] = int(remaining.Seconds())
 		response["can_cancel"] = remaining > 0
 	}
@@ -205,6 +159,159 @@ func (h *ReviewHandler) GetReviewStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// StreamToken issues a short lived token for the review event stream of a
+// submission, passed as access_token since EventSource cannot send headers.
+func (h *ReviewHandler) StreamToken(c echo.Context) error {
+	submission, err := loadAccessibleSubmission(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	token, expiresAt, err := middleware.NewStreamToken(h.cfg.JWTSecret, c.Get("user_id").(uint), submission.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate token")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
+
+// StreamReviewEvents streams review status changes of a submission as
+// Server-Sent Events. A "status" event is sent on connect and on every
+// transition, "countdown" events every second while the request can still
+// be cancelled.
+func (h *ReviewHandler) StreamReviewEvents(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	submissionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid submission id")
+	}
+
+	var submission models.Submission
+	if err := database.DB.Preload("Student").Preload("Assignment").First(&submission, submissionID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "submission not found")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "user not found")
+	}
+
+	if submission.Student.GiteaID != user.GiteaID && !isInstructor(userID, submission.Assignment.CourseID) {
+		return echo.NewHTTPError(http.StatusForbidden, "you don't have access to this submission")
+	}
+
+	// Subscribe before reading the current state so no transition is missed
+	events, unsubscribe := h.events.Subscribe(submission.ID)
+	defer unsubscribe()
+
+	current := pubsub.ReviewEvent{SubmissionID: submission.ID, At: time.Now()}
+	var reviewRequest models.ReviewRequest
+	err = database.DB.Where("submission_id = ?", submission.ID).Order("created_at DESC").First(&reviewRequest).Error
+	if err == nil {
+		current.ReviewRequestID = reviewRequest.ID
+		current.Status = reviewRequest.Status
+		current.CancelDeadline = reviewRequest.CancelDeadline
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	if err := writeReviewStatus(res, current); err != nil {
+		return nil
+	}
+
+	countdown := time.NewTicker(time.Second)
+	defer countdown.Stop()
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			current = event
+			if err := writeReviewStatus(res, event); err != nil {
+				return nil
+			}
+
+		case now := <-countdown.C:
+			if current.Status != models.ReviewStatusPending || current.CancelDeadline == nil {
+				continue
+			}
+			remaining := current.CancelDeadline.Sub(now)
+			if remaining < 0 {
+				remaining = 0
+			}
+			err := writeSSE(res, "countdown", map[string]interface{}{
+				"review_request_id": current.ReviewRequestID,
+				"seconds_remaining": int(remaining.Seconds()),
+				"can_cancel":        remaining > 0,
+			})
+			if err != nil {
+				return nil
+			}
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func writeReviewStatus(res *echo.Response, event pubsub.ReviewEvent) error {
+	data := map[string]interface{}{
+		"submission_id":      event.SubmissionID,
+		"review_request_id":  event.ReviewRequestID,
+		"status":             event.Status,
+		"from_status":        event.FromStatus,
+		"actor":              event.Actor,
+		"at":                 event.At,
+		"has_active_request": event.Status == models.ReviewStatusPending || event.Status == models.ReviewStatusSubmitted,
+	}
+	if event.Status == models.ReviewStatusPending && event.CancelDeadline != nil {
+		remaining := time.Until(*event.CancelDeadline)
+		if remaining < 0 {
+			remaining = 0
+		}
+		data["cancel_deadline"] = event.CancelDeadline
+		data["seconds_remaining"] = int(remaining.Seconds())
+		data["can_cancel"] = remaining > 0
+	}
+	return writeSSE(res, "status", data)
+}
+
+func writeSSE(res *echo.Response, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
+
+type MarkReviewedRequest struct {
+	// One of reviewed, changes_requested, accepted; defaults to reviewed
+	Outcome string `json:"outcome"`
+}
+
 // MarkReviewed is called by webhook or manually by instructor
 func (h *ReviewHandler) MarkReviewed(c echo.Context) error {
 	userID := c.Get("user_id").(uint)
@@ -214,7 +321,7 @@ func (h *ReviewHandler) MarkReviewed(c echo.Context) error {
 	}
 
 	var reviewRequest models.ReviewRequest
-	if err := database.DB.Preload("Submission.Assignment.Course").First(&reviewRequest, reviewRequestID).Error; err != nil {
+	if err := database.DB.Preload("Submission.Student").Preload("Submission.Assignment.Course").First(&reviewRequest, reviewRequestID).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "review request not found")
 	}
 
@@ -227,44 +334,263 @@ func (h *ReviewHandler) MarkReviewed(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "review request is not in submitted status")
 	}
 
-	// Get instructor token
+	var req MarkReviewedRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if req.Outcome == "" {
+		req.Outcome = models.ReviewStatusReviewed
+	}
+	if !models.IsReviewOutcome(req.Outcome) {
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown review outcome")
+	}
+
 	var instructor models.User
 	if err := database.DB.First(&instructor, userID).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "instructor not found")
 	}
 
-	giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, instructor.AccessToken)
+	// Update status
+	err = h.reviews.Apply(&reviewRequest, review.Transition{
+		To:     req.Outcome,
+		Actor:  instructor.Username,
+		Reason: "marked reviewed in the LMS",
+	})
+	if errors.Is(err, review.ErrConflict) {
+		return echo.NewHTTPError(http.StatusConflict, "review request was changed concurrently")
+	}
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize gitea service")
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update review request")
 	}
 
-	// Disable branch protection
-	repoName := extractRepoName(reviewRequest.Submission.RepoURL)
-	orgName := reviewRequest.Submission.Assignment.Course.OrgName
-	giteaService.DisableBranchProtection(orgName, repoName, "main")
+	return c.JSON(http.StatusOK, reviewRequest)
+}
+
+// ListSubmissionReviews returns every review request of a submission with
+// its history events, oldest first. Available to the student who owns the
+// submission and to course instructors.
+func (h *ReviewHandler) ListSubmissionReviews(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	submissionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid submission id")
+	}
+
+	var submission models.Submission
+	if err := database.DB.Preload("Student").Preload("Assignment").First(&submission, submissionID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "submission not found")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "user not found")
+	}
+
+	if submission.Student.GiteaID != user.GiteaID && !isInstructor(userID, submission.Assignment.CourseID) {
+		return echo.NewHTTPError(http.StatusForbidden, "you don't have access to this submission")
+	}
+
+	var reviewRequests []models.ReviewRequest
+	if err := database.DB.Preload("Reviewer").Where("submission_id = ?", submission.ID).
+		Order("created_at ASC").Find(&reviewRequests).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch review requests")
+	}
+
+	var events []models.ReviewEvent
+	if err := database.DB.Where("submission_id = ?", submission.ID).
+		Order("created_at ASC, id ASC").Find(&events).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch review history")
+	}
+
+	type reviewRound struct {
+		models.ReviewRequest
+		Events []models.ReviewEvent `json:"events"`
+	}
+
+	rounds := make([]reviewRound, 0, len(reviewRequests))
+	index := make(map[uint]int, len(reviewRequests))
+	for _, rr := range reviewRequests {
+		index[rr.ID] = len(rounds)
+		rounds = append(rounds, reviewRound{ReviewRequest: rr, Events: []models.ReviewEvent{}})
+	}
+	for _, event := range events {
+		if i, ok := index[event.ReviewRequestID]; ok {
+			rounds[i].Events = append(rounds[i].Events, event)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"submission_id": submission.ID,
+		"rounds":        rounds,
+		"events":        events,
+	})
+}
+
+// ReviewQueueItem is a review request as shown in the instructor review queue
+type ReviewQueueItem struct {
+	models.ReviewRequest
+	RepoURL        string `json:"repo_url"`
+	FeedbackPRURL  string `json:"feedback_pr_url"`
+	WaitingSeconds int64  `json:"waiting_seconds"`
+}
+
+// ListCourseReviews returns the review queue of a course. It can be filtered
+// by status (comma separated), assignment_id and student_id and is ordered by
+// the time the request was submitted for review.
+func (h *ReviewHandler) ListCourseReviews(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	slug := c.Param("slug")
+
+	var course models.Course
+	if err := database.DB.Where("slug = ?", slug).First(&course).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "course not found")
+	}
+
+	if !isInstructor(userID, course.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, "only instructors can view the review queue")
+	}
+
+	statuses := []string{models.ReviewStatusPending, models.ReviewStatusSubmitted}
+	if status := c.QueryParam("status"); status != "" {
+		statuses = strings.Split(status, ",")
+	}
+
+	query := database.DB.
+		Joins("JOIN submissions ON submissions.id = review_requests.submission_id").
+		Joins("JOIN assignments ON assignments.id = submissions.assignment_id").
+		Where("assignments.course_id = ? AND review_requests.status IN ?", course.ID, statuses)
+
+	if assignmentID := c.QueryParam("assignment_id"); assignmentID != "" {
+		id, err := strconv.ParseUint(assignmentID, 10, 32)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid assignment id")
+		}
+		query = query.Where("submissions.assignment_id = ?", id)
+	}
+
+	if studentID := c.QueryParam("student_id"); studentID != "" {
+		id, err := strconv.ParseUint(studentID, 10, 32)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid student id")
+		}
+		query = query.Where("submissions.student_id = ?", id)
+	}
+
+	var reviewRequests []models.ReviewRequest
+	err := query.
+		Preload("Submission.Student").
+		Preload("Submission.Assignment").
+		Preload("Reviewer").
+		Order("review_requests.submitted_at ASC NULLS LAST, review_requests.requested_at ASC").
+		Find(&reviewRequests).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch review requests")
+	}
 
-	// Update status
 	now := time.Now()
-	reviewRequest.Status = models.ReviewStatusReviewed
-	reviewRequest.ReviewedAt = &now
+	items := make([]ReviewQueueItem, 0, len(reviewRequests))
+	for _, rr := range reviewRequests {
+		items = append(items, ReviewQueueItem{
+			ReviewRequest:  rr,
+			RepoURL:        rr.Submission.RepoURL,
+			FeedbackPRURL:  rr.Submission.FeedbackPRURL(),
+			WaitingSeconds: int64(waitingTime(&rr, now).Seconds()),
+		})
+	}
 
-	if err := database.DB.Save(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update review request")
+	return c.JSON(http.StatusOK, items)
+}
+
+// OverdueReviewItem is a submitted review request waiting past the course SLA
+type OverdueReviewItem struct {
+	ReviewQueueItem
+	SLADeadline    time.Time `json:"sla_deadline"`
+	OverdueSeconds int64     `json:"overdue_seconds"`
+}
+
+// ListOverdueReviews returns the course's submitted review requests that have
+// been waiting longer than the review SLA, oldest first.
+func (h *ReviewHandler) ListOverdueReviews(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	slug := c.Param("slug")
+
+	var course models.Course
+	if err := database.DB.Where("slug = ?", slug).First(&course).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "course not found")
 	}
 
-	// Update Google Sheets status if configured
-	if h.sheets != nil && reviewRequest.SheetRowID > 0 {
-		h.sheets.UpdateRowStatus(reviewRequest.SheetRowID, "Проверено")
+	if !isInstructor(userID, course.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, "only instructors can view overdue reviews")
 	}
 
-	return c.JSON(http.StatusOK, reviewRequest)
+	now := time.Now()
+	sla := review.SLA(h.cfg, &course)
+	reviewRequests, err := review.OverdueRequests(course.ID, sla, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch review requests")
+	}
+
+	items := make([]OverdueReviewItem, 0, len(reviewRequests))
+	for _, rr := range reviewRequests {
+		deadline := rr.SubmittedAt.Add(sla)
+		items = append(items, OverdueReviewItem{
+			ReviewQueueItem: ReviewQueueItem{
+				ReviewRequest:  rr,
+				RepoURL:        rr.Submission.RepoURL,
+				FeedbackPRURL:  rr.Submission.FeedbackPRURL(),
+				WaitingSeconds: int64(waitingTime(&rr, now).Seconds()),
+			},
+			SLADeadline:    deadline,
+			OverdueSeconds: int64(now.Sub(deadline).Seconds()),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sla_days": int(sla.Hours() / 24),
+		"overdue":  items,
+	})
 }
 
-func extractRepoName(repoURL string) string {
-	// Extract repo name from URL like "https://gitea.example.com/org/repo-name"
-	parts := strings.Split(repoURL, "/")
-	if len(parts) > 0 {
-		return parts[len(parts)-1]
+// ReviewStats returns per-instructor review turnaround for a course.
+func (h *ReviewHandler) ReviewStats(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	slug := c.Param("slug")
+
+	var course models.Course
+	if err := database.DB.Where("slug = ?", slug).First(&course).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "course not found")
+	}
+
+	if !isInstructor(userID, course.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, "only instructors can view review statistics")
+	}
+
+	sla := review.SLA(h.cfg, &course)
+	stats, err := review.CourseTurnaround(course.ID, sla, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to compute review statistics")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sla_days":    int(sla.Hours() / 24),
+		"instructors": stats,
+	})
+}
+
+// waitingTime is how long a request has been waiting for an instructor,
+// counted from submission (or the request itself while still pending) until
+// it was reviewed.
+func waitingTime(rr *models.ReviewRequest, now time.Time) time.Duration {
+	start := rr.RequestedAt
+	if rr.SubmittedAt != nil {
+		start = *rr.SubmittedAt
+	}
+	end := now
+	if rr.ReviewedAt != nil {
+		end = *rr.ReviewedAt
+	}
+	if end.Before(start) {
+		return 0
 	}
-	return ""
+	return end.Sub(start)
 }
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type RubricHandler struct {
+	cfg *config.Config
+}
+
+func NewRubricHandler(cfg *config.Config) *RubricHandler {
+	return &RubricHandler{cfg: cfg}
+}
+
+// RubricRequest replaces the criteria of an assignment rubric. Criteria
+// with an ID are updated and keep their scores, criteria left out are
+// deleted with their scores. No criteria removes the rubric.
+type RubricRequest struct {
+	Criteria []RubricCriterionRequest `json:"criteria"`
+}
+
+type RubricCriterionRequest struct {
+	ID          uint                      `json:"id"`
+	Title       string                    `json:"title"`
+	Description string                    `json:"description"`
+	MaxPoints   int                       `json:"max_points"`
+	Descriptors []RubricDescriptorRequest `json:"descriptors"`
+}
+
+type RubricDescriptorRequest struct {
+	Points      int    `json:"points"`
+	Description string `json:"description"`
+}
+
+// Get returns the rubric of an assignment, 404 when it has none.
+func (h *RubricHandler) Get(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid assignment id")
+	}
+
+	rubric, err := loadRubric(database.DB, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "assignment has no rubric")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch rubric")
+	}
+
+	return c.JSON(http.StatusOK, rubric)
+}
+
+// Update sets the rubric of an assignment.
+func (h *RubricHandler) Update(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid assignment id")
+	}
+
+	var assignment models.Assignment
+	if err := database.DB.First(&assignment, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "assignment not found")
+	}
+
+	if !isInstructor(userID, assignment.CourseID) {
+		return echo.NewHTTPError(http.StatusForbidden, "only instructors can edit rubrics")
+	}
+
+	var req RubricRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := req.validate(&assignment); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var rubric *models.Rubric
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		rubric, err = saveRubric(tx, &assignment, req.Criteria)
+		return err
+	})
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save rubric")
+	}
+
+	if rubric == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, rubric)
+}
+
+// validate checks the criteria against the assignment. The criteria must
+// not be worth more than the assignment.
+func (r RubricRequest) validate(assignment *models.Assignment) error {
+	total := 0
+	seen := make(map[uint]bool)
+	for i, criterion := range r.Criteria {
+		if strings.TrimSpace(criterion.Title) == "" {
+			return fmt.Errorf("criterion %d has no title", i+1)
+		}
+		if criterion.MaxPoints <= 0 {
+			return fmt.Errorf("criterion %q must be worth at least one point", criterion.Title)
+		}
+		if criterion.ID != 0 {
+			if seen[criterion.ID] {
+				return fmt.Errorf("criterion %d is listed twice", criterion.ID)
+			}
+			seen[criterion.ID] = true
+		}
+		for _, descriptor := range criterion.Descriptors {
+			if descriptor.Points < 0 || descriptor.Points > criterion.MaxPoints {
+				return fmt.Errorf("descriptor points of criterion %q must be between 0 and %d", criterion.Title, criterion.MaxPoints)
+			}
+		}
+		total += criterion.MaxPoints
+	}
+
+	if assignment.MaxPoints > 0 && total > assignment.MaxPoints {
+		return fmt.Errorf("criteria are worth %d points, the assignment only %d", total, assignment.MaxPoints)
+	}
+	return nil
+}
+
+// saveRubric stores the criteria as the rubric of the assignment, nil when
+// the rubric was removed.
+func saveRubric(tx *gorm.DB, assignment *models.Assignment, criteria []RubricCriterionRequest) (*models.Rubric, error) {
+	var rubric models.Rubric
+	err := tx.Where("assignment_id = ?", assignment.ID).First(&rubric).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if len(criteria) == 0 {
+			return nil, nil
+		}
+		rubric = models.Rubric{AssignmentID: assignment.ID}
+		if err := tx.Create(&rubric).Error; err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	var existing []models.RubricCriterion
+	if err := tx.Where("rubric_id = ?", rubric.ID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	kept := make(map[uint]bool)
+	for _, criterion := range criteria {
+		kept[criterion.ID] = true
+	}
+	var removed []uint
+	for _, criterion := range existing {
+		if !kept[criterion.ID] {
+			removed = append(removed, criterion.ID)
+		}
+	}
+	if err := deleteCriteria(tx, removed); err != nil {
+		return nil, err
+	}
+
+	if len(criteria) == 0 {
+		if err := tx.Delete(&rubric).Error; err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	for i, req := range criteria {
+		criterion := models.RubricCriterion{
+			ID:          req.ID,
+			RubricID:    rubric.ID,
+			Position:    i,
+			Title:       strings.TrimSpace(req.Title),
+			Description: req.Description,
+			MaxPoints:   req.MaxPoints,
+		}
+		if req.ID != 0 {
+			result := tx.Model(&models.RubricCriterion{}).Where("id = ? AND rubric_id = ?", req.ID, rubric.ID).
+				Select("position", "title", "description", "max_points").Updates(&criterion)
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("criterion %d is not part of this rubric", req.ID))
+			}
+			if err := tx.Where("criterion_id = ?", req.ID).Delete(&models.RubricDescriptor{}).Error; err != nil {
+				return nil, err
+			}
+		} else if err := tx.Create(&criterion).Error; err != nil {
+			return nil, err
+		}
+
+		for _, d := range req.Descriptors {
+			descriptor := models.RubricDescriptor{CriterionID: criterion.ID, Points: d.Points, Description: d.Description}
+			if err := tx.Create(&descriptor).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Model(&rubric).Update("updated_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	return loadRubric(tx, assignment.ID)
+}
+
+// deleteCriteria deletes criteria with their descriptors and scores.
+func deleteCriteria(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Where("criterion_id IN ?", ids).Delete(&models.CriterionScore{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("criterion_id IN ?", ids).Delete(&models.RubricDescriptor{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.RubricCriterion{}, ids).Error
+}
+
+// loadRubric loads the rubric of an assignment with its criteria in order.
+func loadRubric(db *gorm.DB, assignmentID uint) (*models.Rubric, error) {
+	var rubric models.Rubric
+	err := db.Where("assignment_id = ?", assignmentID).
+		Preload("Criteria", orderCriteria).
+		Preload("Criteria.Descriptors", orderDescriptors).
+		First(&rubric).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rubric, nil
+}
+
+func orderCriteria(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+func orderDescriptors(db *gorm.DB) *gorm.DB {
+	return db.Order("points DESC, id")
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,17 +12,24 @@ import (
 	"code.gitea.io/sdk/gitea"
 	"github.com/Mond1c/gitea-classroom/config"
 	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/grading"
+	"github.com/Mond1c/gitea-classroom/internal/hooks"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
 	"github.com/Mond1c/gitea-classroom/internal/models"
 	"github.com/Mond1c/gitea-classroom/internal/services"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SubmissionHandler struct {
-	cfg *config.Config
+	cfg   *config.Config
+	hooks *hooks.Manager
+	jobs  *jobs.Queue
 }
 
-func NewSubmissionHandler(cfg *config.Config) *SubmissionHandler {
-	return &SubmissionHandler{cfg: cfg}
+func NewSubmissionHandler(cfg *config.Config, hookManager *hooks.Manager, queue *jobs.Queue) *SubmissionHandler {
+	return &SubmissionHandler{cfg: cfg, hooks: hookManager, jobs: queue}
 }
 
 func (h *SubmissionHandler) Accept(c echo.Context) error {
@@ -69,6 +78,7 @@ func (h *SubmissionHandler) Accept(c echo.Context) error {
 		slugify(assignment.Title),
 		user.Username)
 	var repoURL string
+	var repoID int64
 
 	// Check if repository already exists
 	if submissionExists && giteaService.RepositoryExists(assignment.Course.OrgName, repoName) {
@@ -94,6 +104,7 @@ func (h *SubmissionHandler) Accept(c echo.Context) error {
 				return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to create repository from template: %v", err))
 			}
 			repoURL = repo.HTMLURL
+			repoID = repo.ID
 
 			// Copy repository settings from template
 			go func() {
@@ -113,6 +124,7 @@ func (h *SubmissionHandler) Accept(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create repository")
 		}
 		repoURL = repo.HTMLURL
+		repoID = repo.ID
 	}
 
 	giteaService.AddCollaborator(assignment.Course.OrgName, repoName, user.Username, gitea.AccessModeWrite)
@@ -125,28 +137,20 @@ func (h *SubmissionHandler) Accept(c echo.Context) error {
 		}
 	}
 
-	// Create webhook for review system if configured
-	if h.cfg.WebhookBaseURL != "" && h.cfg.GiteaWebhookSecret != "" {
-		webhookURL := fmt.Sprintf("%s/api/webhooks/gitea", h.cfg.WebhookBaseURL)
-		go func() {
-			giteaService.CreateRepoWebhook(
-				assignment.Course.OrgName,
-				repoName,
-				webhookURL,
-				h.cfg.GiteaWebhookSecret,
-				[]string{"pull_request_comment", "pull_request_review"},
-			)
-		}()
+	// Make sure the review system receives webhooks for the repository,
+	// the hook worker repairs it when this fails
+	if h.hooks.Enabled() {
+		if _, err := h.hooks.EnsureRepoHook(assignment.Course.OrgName, repoName); err != nil {
+			log.Printf("Warning: failed to set up webhook for %s/%s: %v", assignment.Course.OrgName, repoName, err)
+		}
 	}
 
-	go func() {
-		giteaService.SetupFeedbackBranch(assignment.Course.OrgName, repoName)
-	}()
-
 	// Create or update submission
 	if submissionExists {
 		// Update existing submission with new repo URL
 		existingSubmission.RepoURL = repoURL
+		existingSubmission.GiteaRepoID = repoID
+		existingSubmission.FeedbackPRNumber = 0
 		existingSubmission.Status = "in_progress"
 		existingSubmission.Score = nil
 		existingSubmission.Feedback = ""
@@ -155,6 +159,7 @@ func (h *SubmissionHandler) Accept(c echo.Context) error {
 		if err := database.DB.Save(&existingSubmission).Error; err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update submission")
 		}
+		go setupFeedbackPR(giteaService, assignment.Course.OrgName, repoName, existingSubmission.ID)
 
 		return c.JSON(http.StatusOK, existingSubmission)
 	} else {
@@ -163,17 +168,35 @@ func (h *SubmissionHandler) Accept(c echo.Context) error {
 			AssignmentID: uint(assignmentID),
 			StudentID:    student.ID,
 			RepoURL:      repoURL,
+			GiteaRepoID:  repoID,
 			Status:       "in_progress",
 		}
 
 		if err := database.DB.Create(&submission).Error; err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create submission")
 		}
+		go setupFeedbackPR(giteaService, assignment.Course.OrgName, repoName, submission.ID)
 
 		return c.JSON(http.StatusCreated, submission)
 	}
 }
 
+// setupFeedbackPR creates the Feedback PR and records its number on the
+// submission. The backfill job finds PRs whose number was not recorded.
+func setupFeedbackPR(giteaService *services.GiteaService, orgName, repoName string, submissionID uint) {
+	pr, err := giteaService.SetupFeedbackBranch(orgName, repoName)
+	if err != nil {
+		log.Printf("Warning: failed to set up Feedback PR for %s/%s: %v", orgName, repoName, err)
+		return
+	}
+
+	err = database.DB.Model(&models.Submission{}).Where("id = ?", submissionID).
+		Update("feedback_pr_number", pr.Index).Error
+	if err != nil {
+		log.Printf("Warning: failed to record Feedback PR of submission %d: %v", submissionID, err)
+	}
+}
+
 func (h *SubmissionHandler) List(c echo.Context) error {
 	userID := c.Get("user_id").(uint)
 	assignmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -205,16 +228,162 @@ func (h *SubmissionHandler) Get(c echo.Context) error {
 	}
 
 	var submission models.Submission
-	if err := database.DB.Preload("Student").Preload("Assignment").First(&submission, id).Error; err != nil {
+	err = database.DB.Preload("Student").Preload("Assignment").
+		Preload("Assignment.Rubric").
+		Preload("Assignment.Rubric.Criteria", orderCriteria).
+		Preload("Assignment.Rubric.Criteria.Descriptors", orderDescriptors).
+		Preload("CriterionScores").
+		First(&submission, id).Error
+	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "submission not found")
 	}
 
+	// Checks behind the CI state
+	if submission.CISHA != "" {
+		err := database.DB.Where("submission_id = ? AND sha = ?", submission.ID, submission.CISHA).
+			Order("context").Find(&submission.Checks).Error
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch checks")
+		}
+	}
+
 	return c.JSON(http.StatusOK, submission)
 }
 
+// ListCommits returns the commits pushed to a submission, newest first.
+// Available to the student who owns the submission and to course instructors.
+func (h *SubmissionHandler) ListCommits(c echo.Context) error {
+	submission, err := loadAccessibleSubmission(c, c.Param("submissionId"))
+	if err != nil {
+		return err
+	}
+
+	query := database.DB.Where("submission_id = ?", submission.ID)
+	if branch := c.QueryParam("branch"); branch != "" {
+		query = query.Where("branch = ?", branch)
+	}
+
+	var commits []models.Commit
+	if err := query.Order("pushed_at DESC, timestamp DESC").Find(&commits).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch commits")
+	}
+
+	return c.JSON(http.StatusOK, commits)
+}
+
+// ListChecks returns the CI checks of a submission, newest first, optionally
+// of a single commit. Available to the owner and to course instructors.
+func (h *SubmissionHandler) ListChecks(c echo.Context) error {
+	submission, err := loadAccessibleSubmission(c, c.Param("submissionId"))
+	if err != nil {
+		return err
+	}
+
+	query := database.DB.Where("submission_id = ?", submission.ID)
+	if sha := c.QueryParam("sha"); sha != "" {
+		query = query.Where("sha = ?", sha)
+	}
+
+	var checks []models.CommitCheck
+	if err := query.Order("reported_at DESC").Find(&checks).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch checks")
+	}
+
+	return c.JSON(http.StatusOK, checks)
+}
+
+type AutogradeRequest struct {
+	// Branch, tag or SHA. When empty the deadline snapshot once the deadline
+	// passed, the default branch before.
+	Ref string `json:"ref"`
+}
+
+// Autograde queues a grading run of the submission. Instructors only.
+func (h *SubmissionHandler) Autograde(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	id, err := strconv.ParseUint(c.Param("submissionId"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid submission id")
+	}
+
+	var submission models.Submission
+	if err := database.DB.Preload("Assignment").First(&submission, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "submission not found")
+	}
+
+	if !isInstructor(userID, submission.Assignment.CourseID) {
+		return echo.NewHTTPError(http.StatusForbidden, "only instructors can run autograding")
+	}
+	if !grading.Enabled(&submission.Assignment) {
+		return echo.NewHTTPError(http.StatusBadRequest, "autograding is not configured for this assignment")
+	}
+	if !grading.SandboxConfigured(h.cfg) {
+		return echo.NewHTTPError(http.StatusBadRequest, "autograding needs a grading sandbox on the server")
+	}
+	if submission.RepoURL == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "submission has no repository")
+	}
+
+	var req AutogradeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	ref := strings.TrimSpace(req.Ref)
+	if ref == "" {
+		ref = submission.DeadlineSHA
+	}
+	run, err := grading.Enqueue(h.jobs, &submission, ref, models.GradingTriggerManual, &userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to queue grading run")
+	}
+
+	return c.JSON(http.StatusAccepted, run)
+}
+
+// ListGradingRuns returns the autograding runs of a submission, newest
+// first. Available to the owner and to course instructors.
+func (h *SubmissionHandler) ListGradingRuns(c echo.Context) error {
+	submission, err := loadAccessibleSubmission(c, c.Param("submissionId"))
+	if err != nil {
+		return err
+	}
+
+	var runs []models.GradingRun
+	err = database.DB.Preload("Tests", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("submission_id = ?", submission.ID).Order("id DESC").Find(&runs).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch grading runs")
+	}
+
+	// Output and failure messages of hidden tests may show their code,
+	// students only see names and points
+	userID := c.Get("user_id").(uint)
+	if submission.Assignment.TestsRepo != "" && !isInstructor(userID, submission.Assignment.CourseID) {
+		for i := range runs {
+			runs[i].Log = ""
+			for j := range runs[i].Tests {
+				runs[i].Tests[j].Output = ""
+				runs[i].Tests[j].Feedback = ""
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, runs)
+}
+
+// GradeRequest grades a submission with a score, or with the scores of all
+// rubric criteria, which add up to the score.
 type GradeRequest struct {
-	Score    int    `json:"score"`
-	Feedback string `json:"feedback"`
+	Score    int                     `json:"score"`
+	Feedback string                  `json:"feedback"`
+	Criteria []CriterionScoreRequest `json:"criteria"`
+}
+
+type CriterionScoreRequest struct {
+	CriterionID uint   `json:"criterion_id"`
+	Points      int    `json:"points"`
+	Comment     string `json:"comment"`
 }
 
 func (h *SubmissionHandler) Grade(c echo.Context) error {
@@ -238,21 +407,99 @@ func (h *SubmissionHandler) Grade(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
 	}
 
+	var scores []models.CriterionScore
+	if len(req.Criteria) > 0 {
+		scores, err = rubricScores(submission.AssignmentID, req.Criteria, userID)
+		if err != nil {
+			return err
+		}
+		req.Score = 0
+		for _, score := range scores {
+			req.Score += score.Points
+		}
+	}
+
 	if req.Score < 0 || req.Score > submission.Assignment.MaxPoints {
 		return echo.NewHTTPError(http.StatusBadRequest, "score out of range")
 	}
 
-	submission.Score = &req.Score
-	submission.Feedback = req.Feedback
+	if err := applyGrade(&submission, req.Score, req.Feedback, scores); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to grade submission")
+	}
+
+	return c.JSON(http.StatusOK, submission)
+}
+
+// rubricScores checks that the request scores every criterion of the
+// assignment rubric once and within its points.
+func rubricScores(assignmentID uint, criteria []CriterionScoreRequest, graderID uint) ([]models.CriterionScore, error) {
+	rubric, err := loadRubric(database.DB, assignmentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "assignment has no rubric")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch rubric")
+	}
+
+	requested := make(map[uint]CriterionScoreRequest, len(criteria))
+	for _, score := range criteria {
+		if _, ok := requested[score.CriterionID]; ok {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("criterion %d is scored twice", score.CriterionID))
+		}
+		requested[score.CriterionID] = score
+	}
+
+	scores := make([]models.CriterionScore, 0, len(rubric.Criteria))
+	for _, criterion := range rubric.Criteria {
+		score, ok := requested[criterion.ID]
+		if !ok {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("criterion %q is not scored", criterion.Title))
+		}
+		if score.Points < 0 || score.Points > criterion.MaxPoints {
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("points of criterion %q must be between 0 and %d", criterion.Title, criterion.MaxPoints))
+		}
+		delete(requested, criterion.ID)
+		scores = append(scores, models.CriterionScore{
+			CriterionID: criterion.ID,
+			Points:      score.Points,
+			Comment:     score.Comment,
+			GradedByID:  &graderID,
+		})
+	}
+	for id := range requested {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("criterion %d is not part of the rubric", id))
+	}
+	return scores, nil
+}
+
+// applyGrade stores the score and feedback and marks the submission graded.
+// The score must already be validated against the assignment's max points.
+// The criterion scores replace the previous ones, a grade without them
+// clears the rubric breakdown.
+func applyGrade(submission *models.Submission, score int, feedback string, scores []models.CriterionScore) error {
+	submission.Score = &score
+	submission.Feedback = feedback
 	submission.Status = "graded"
 	now := time.Now()
 	submission.SubmittedAt = &now
 
-	if err := database.DB.Save(&submission).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to grade submission")
-	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("submission_id = ?", submission.ID).Delete(&models.CriterionScore{}).Error; err != nil {
+			return err
+		}
+		for i := range scores {
+			scores[i].SubmissionID = submission.ID
+		}
+		if len(scores) > 0 {
+			if err := tx.Create(&scores).Error; err != nil {
+				return err
+			}
+		}
+		submission.CriterionScores = scores
 
-	return c.JSON(http.StatusOK, submission)
+		return tx.Omit(clause.Associations).Save(submission).Error
+	})
 }
 
 func slugify(s string) string {
@@ -12,26 +12,31 @@ import (
 	"strings"
 	"time"
 
-	"code.gitea.io/sdk/gitea"
 	"github.com/Mond1c/gitea-classroom/config"
-	"github.com/Mond1c/gitea-classroom/internal/cache"
+	"github.com/Mond1c/gitea-classroom/internal/bot"
 	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
 	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
 	"github.com/Mond1c/gitea-classroom/internal/services"
 	"github.com/labstack/echo/v4"
 )
 
 type WebhookHandler struct {
-	cfg    *config.Config
-	cache  *cache.ReviewCache
-	sheets *services.SheetsService
+	cfg      *config.Config
+	reviews  *review.Machine
+	assigner *review.Assigner
+	bot      *bot.Bot
+	jobs     *jobs.Queue
 }
 
-func NewWebhookHandler(cfg *config.Config, reviewCache *cache.ReviewCache, sheets *services.SheetsService) *WebhookHandler {
+func NewWebhookHandler(cfg *config.Config, reviews *review.Machine, assigner *review.Assigner, replies *bot.Bot, queue *jobs.Queue) *WebhookHandler {
 	return &WebhookHandler{
-		cfg:    cfg,
-		cache:  reviewCache,
-		sheets: sheets,
+		cfg:      cfg,
+		reviews:  reviews,
+		assigner: assigner,
+		bot:      replies,
+		jobs:     queue,
 	}
 }
 
@@ -51,6 +56,12 @@ type GiteaPullRequestPayload struct {
 			Ref string `json:"ref"`
 		} `json:"base"`
 	} `json:"pull_request"`
+	// Set on the "reviewed" action and the pull_request_approved,
+	// pull_request_rejected and pull_request_comment events
+	Review struct {
+		Type    string `json:"type"`
+		Content string `json:"content"`
+	} `json:"review"`
 	Repository struct {
 		ID       int64  `json:"id"`
 		Name     string `json:"name"`
@@ -80,9 +91,10 @@ type GiteaPullRequestReviewPayload struct {
 		State string `json:"state"` // "APPROVED", "COMMENT", "REQUEST_CHANGES"
 	} `json:"review"`
 	PullRequest struct {
-		ID    int64  `json:"id"`
-		Title string `json:"title"`
-		Base  struct {
+		ID     int64  `json:"id"`
+		Number int64  `json:"number"`
+		Title  string `json:"title"`
+		Base   struct {
 			Ref string `json:"ref"`
 		} `json:"base"`
 	} `json:"pull_request"`
@@ -147,68 +159,100 @@ func (h *WebhookHandler) HandleGiteaWebhook(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to read body")
 	}
 
+	delivery := newWebhookDelivery(c.Request().Header.Get("X-Gitea-Event"), c.Request().Header.Get("X-Gitea-Delivery"), body)
+	saveWebhookDelivery(delivery)
+
 	// Verify signature if secret is configured
 	if h.cfg.GiteaWebhookSecret != "" {
 		signature := c.Request().Header.Get("X-Gitea-Signature")
 		if !verifySignature(body, signature, h.cfg.GiteaWebhookSecret) {
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid signature")
+			err := echo.NewHTTPError(http.StatusUnauthorized, "invalid signature")
+			finishWebhookDelivery(delivery, webhookStatus(webhookStatusInvalidSignature), err)
+			return err
 		}
 	}
 
-	// Check event type
-	eventType := c.Request().Header.Get("X-Gitea-Event")
+	// Redeliveries of an action already handled get its outcome right away
+	if original := handledDelivery(delivery); original != nil {
+		result := finishDuplicateDelivery(delivery, original)
+		return c.JSON(http.StatusOK, result.response())
+	}
 
-	log.Printf("%v", eventType)
+	// Gitea only waits a few seconds, the job worker handles the delivery
+	if delivery.ID == 0 {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to store delivery")
+	}
+	if _, err := h.jobs.Enqueue(JobKindWebhook, webhookJob{DeliveryID: delivery.ID}); err != nil {
+		log.Printf("Warning: failed to queue webhook delivery %d: %v", delivery.ID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to queue delivery")
+	}
 
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"status":      "queued",
+		"delivery_id": delivery.ID,
+	})
+}
+
+// process dispatches a webhook body by its X-Gitea-Event type. receivedAt
+// is when Gitea delivered it, handling may happen later.
+func (h *WebhookHandler) process(eventType string, body []byte, receivedAt time.Time) (webhookResult, error) {
 	switch eventType {
+	case "push":
+		return h.handlePush(body, receivedAt)
 	case "pull_request":
-		return h.handlePullRequest(c, body)
+		return h.handlePullRequest(body)
 	case "pull_request_review":
-		return h.handlePullRequestReview(c, body)
+		return h.handlePullRequestReview(body)
 	case "pull_request_rejected":
 		// Restore access when instructor requests changes
-		return h.handleReviewed(c, body)
+		return h.handleReviewed(eventType, body)
 	case "pull_request_approved":
 		// Restore access when instructor approves
-		return h.handleReviewed(c, body)
+		return h.handleReviewed(eventType, body)
+	case "pull_request_comment":
+		// Reviews submitted as a comment, with a pull request payload
+		return h.handleReviewed(eventType, body)
 	case "issue_comment":
-		return h.handleIssueComment(c, body)
+		return h.handleIssueComment(body)
+	case "status":
+		return h.handleStatus(body, receivedAt)
+	case "workflow_run":
+		return h.handleWorkflowRun(body, receivedAt)
 	default:
-		return c.JSON(http.StatusOK, map[string]string{"status": "ignored"})
+		return webhookStatus("ignored"), nil
 	}
 }
 
 // Handle pull_request events (review_requested, reviewed)
-func (h *WebhookHandler) handlePullRequest(c echo.Context, body []byte) error {
+func (h *WebhookHandler) handlePullRequest(body []byte) (webhookResult, error) {
 	var payload GiteaPullRequestPayload
 	if err := json.Unmarshal(body, &payload); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+		return webhookResult{}, echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
 	}
 
 	// Handle different actions
 	if payload.Action == "review_requested" {
-		return h.handleReviewRequested(c, payload)
+		return h.handleReviewRequested(payload)
 	} else if payload.Action == "reviewed" {
-		return h.handleReviewed(c, body)
+		return h.handleReviewed("pull_request", body)
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"status": "ignored"})
+	return webhookStatus("ignored"), nil
 }
 
 // Handle review_requested action
-func (h *WebhookHandler) handleReviewRequested(c echo.Context, payload GiteaPullRequestPayload) error {
+func (h *WebhookHandler) handleReviewRequested(payload GiteaPullRequestPayload) (webhookResult, error) {
+	// Find submission by Gitea repository
+	submission, found := findSubmission(payload.Repository.ID, payload.Repository.HTMLURL)
 
 	// Only process Feedback PR
-	if payload.PullRequest.Title != "Feedback" {
-		return c.JSON(http.StatusOK, map[string]string{"status": "ignored"})
+	if !isFeedbackPR(submission, found, payload.Number, payload.PullRequest.Title) {
+		return webhookStatus("ignored"), nil
 	}
-
-	// Find submission by repo URL
-	repoURL := payload.Repository.HTMLURL
-	var submission models.Submission
-	if err := database.DB.Preload("Student").Preload("Assignment.Course").Where("repo_url = ?", repoURL).First(&submission).Error; err != nil {
-		return echo.NewHTTPError(http.StatusOK, map[string]string{"status": "submission_not_found"})
+	if !found {
+		return webhookStatus("submission_not_found"), nil
 	}
+	rememberFeedbackPR(&submission, payload.Number)
 
 	// Check if student is the one requesting review
 	requesterUsername := payload.Sender.Username
@@ -217,7 +261,7 @@ func (h *WebhookHandler) handleReviewRequested(c echo.Context, payload GiteaPull
 	}
 
 	if submission.Student.Username != requesterUsername {
-		return echo.NewHTTPError(http.StatusOK, map[string]string{"status": "not_submission_owner"})
+		return webhookStatus("not_submission_owner"), nil
 	}
 
 	// Check for existing active review request
@@ -225,180 +269,87 @@ func (h *WebhookHandler) handleReviewRequested(c echo.Context, payload GiteaPull
 	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
 		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).First(&existingRequest).Error
 	if err == nil {
-		return echo.NewHTTPError(http.StatusOK, map[string]string{"status": "review_already_active"})
-	}
-
-	// Get instructor to use their token for branch protection
-	var instructor models.User
-	err = database.DB.
-		Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
-		Where("course_instructors.course_id = ?", submission.Assignment.CourseID).
-		First(&instructor).Error
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "no instructor found")
-	}
-
-	giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, instructor.AccessToken)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize gitea service")
-	}
-
-	// Enable branch protection
-	repoName := extractRepoName(submission.RepoURL)
-	orgName := submission.Assignment.Course.OrgName
-	if err := giteaService.EnableBranchProtection(orgName, repoName, "main"); err != nil {
-		fmt.Printf("Warning: failed to enable branch protection: %v\n", err)
+		return webhookStatus("review_already_active"), nil
 	}
 
 	// Create review request
-	reviewRequest := models.ReviewRequest{
-		SubmissionID: submission.ID,
-		Status:       models.ReviewStatusPending,
-		RequestedAt:  time.Now(),
-	}
-
-	if err := database.DB.Create(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create review request")
+	reviewRequest, err := h.reviews.Request(submission, requesterUsername, "review requested on the Feedback PR")
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to create review request")
 	}
 
-	// Add to cache with TTL
-	ttl := time.Duration(h.cfg.ReviewPendingMinutes) * time.Minute
-	h.cache.Add(reviewRequest.ID, submission.ID, ttl)
-
 	fmt.Printf("Review request created: ID=%d, Submission=%d, TTL=%v minutes, Expires at=%v\n",
-		reviewRequest.ID, submission.ID, h.cfg.ReviewPendingMinutes, reviewRequest.RequestedAt.Add(ttl))
+		reviewRequest.ID, submission.ID, h.cfg.ReviewPendingMinutes, reviewRequest.CancelDeadline)
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"status":            "review_requested",
-		"review_request_id": reviewRequest.ID,
-		"cancel_deadline":   reviewRequest.RequestedAt.Add(ttl),
-	})
+	return webhookStatus("review_requested").
+		with("review_request_id", reviewRequest.ID).
+		with("cancel_deadline", reviewRequest.CancelDeadline), nil
 }
 
 // Handle pull_request_review events (submitted reviews)
-func (h *WebhookHandler) handlePullRequestReview(c echo.Context, body []byte) error {
+func (h *WebhookHandler) handlePullRequestReview(body []byte) (webhookResult, error) {
 	var payload GiteaPullRequestReviewPayload
 	if err := json.Unmarshal(body, &payload); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+		return webhookResult{}, echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
 	}
 
 	// Only process submitted reviews
 	if payload.Action != "submitted" {
-		return echo.NewHTTPError(http.StatusOK, map[string]string{"status": "ignored"})
+		return webhookStatus("ignored"), nil
 	}
 
+	// Find submission by Gitea repository
+	submission, found := findSubmission(payload.Repository.ID, payload.Repository.HTMLURL)
+
 	// Only process reviews on Feedback PR
-	if payload.PullRequest.Title != "Feedback" {
-		return c.JSON(http.StatusOK, map[string]string{"status": "ignored"})
+	if !isFeedbackPR(submission, found, payload.PullRequest.Number, payload.PullRequest.Title) {
+		return webhookStatus("ignored"), nil
 	}
-
-	// Find submission by repo URL
-	repoURL := payload.Repository.HTMLURL
-	var submission models.Submission
-	if err := database.DB.Preload("Assignment.Course").Where("repo_url = ?", repoURL).First(&submission).Error; err != nil {
+	if !found {
 		// Submission not found, might be a repo we don't track
-		return c.JSON(http.StatusOK, map[string]string{"status": "submission_not_found"})
+		return webhookStatus("submission_not_found"), nil
 	}
+	rememberFeedbackPR(&submission, payload.PullRequest.Number)
 
-	// Find active review request for this submission
-	var reviewRequest models.ReviewRequest
-	err := database.DB.Where("submission_id = ? AND status = ?", submission.ID, models.ReviewStatusSubmitted).
-		First(&reviewRequest).Error
-	if err != nil {
-		// No active review request
-		return c.JSON(http.StatusOK, map[string]string{"status": "no_active_review"})
-	}
-
-	// Check if reviewer is an instructor for this course's year
 	reviewerUsername := payload.Sender.Username
 	if reviewerUsername == "" {
 		reviewerUsername = payload.Sender.Login
 	}
-
-	// Get instructor token to check team membership
-	var instructor models.User
-	err = database.DB.
-		Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
-		Where("course_instructors.course_id = ?", submission.Assignment.CourseID).
-		First(&instructor).Error
-	if err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "no_instructor"})
-	}
-
-	giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, instructor.AccessToken)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize gitea service")
-	}
-
-	// Check if reviewer is in instructor team
-	teamName := fmt.Sprintf("%d-%s-instructors", submission.Assignment.AcademicYear, submission.Assignment.Course.Slug)
-	isMember, err := giteaService.IsTeamMember(submission.Assignment.Course.OrgName, teamName, reviewerUsername)
-	if err != nil || !isMember {
-		// Reviewer is not an instructor, ignore
-		return c.JSON(http.StatusOK, map[string]string{"status": "reviewer_not_instructor"})
-	}
-
-	// Restore student's write access using admin token
-	repoName := extractRepoName(submission.RepoURL)
-	orgName := submission.Assignment.Course.OrgName
-
-	if h.cfg.GiteaAdminToken != "" {
-		adminService, err := services.NewGiteaService(h.cfg.GiteaURL, h.cfg.GiteaAdminToken)
-		if err == nil {
-			// Restore Write access to student
-			if err := adminService.AddCollaborator(orgName, repoName, submission.Student.Username, gitea.AccessModeWrite); err != nil {
-				log.Printf("Warning: failed to restore student write access: %v", err)
-			} else {
-				log.Printf("Restored write access for student %s on %s/%s", submission.Student.Username, orgName, repoName)
-			}
-		}
-	}
-
-	// Update review request status
-	now := time.Now()
-	reviewRequest.Status = models.ReviewStatusReviewed
-	reviewRequest.ReviewedAt = &now
-
-	if err := database.DB.Save(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update review request")
-	}
-
-	// Update Google Sheets status
-	if h.sheets != nil && reviewRequest.SheetRowID > 0 {
-		h.sheets.UpdateRowStatus(reviewRequest.SheetRowID, "Проверено")
-	}
-
-	return c.JSON(http.StatusOK, map[string]string{
-		"status":            "processed",
-		"review_request_id": fmt.Sprintf("%d", reviewRequest.ID),
+	return h.finishReview(submission, models.ReviewEvent{
+		Actor:         reviewerUsername,
+		Reason:        "review submitted on the Feedback PR",
+		Verdict:       review.NormalizeVerdict(payload.Review.State),
+		Body:          payload.Review.Body,
+		GiteaReviewID: payload.Review.ID,
 	})
 }
 
 // Handle "reviewed" action from pull_request event (when using Request changes/Approve buttons)
-func (h *WebhookHandler) handleReviewed(c echo.Context, body []byte) error {
-	// Parse as generic map to get review type
-	var payload map[string]interface{}
+func (h *WebhookHandler) handleReviewed(eventType string, body []byte) (webhookResult, error) {
+	var payload GiteaPullRequestPayload
 	if err := json.Unmarshal(body, &payload); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+		return webhookResult{}, echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
 	}
 
-	// Extract repository URL
-	repository, ok := payload["repository"].(map[string]interface{})
-	if !ok {
-		return c.JSON(http.StatusOK, map[string]string{"status": "invalid_repository"})
-	}
+	// Find submission by Gitea repository
+	submission, found := findSubmission(payload.Repository.ID, payload.Repository.HTMLURL)
 
-	repoURL, ok := repository["html_url"].(string)
-	if !ok {
-		return c.JSON(http.StatusOK, map[string]string{"status": "invalid_repo_url"})
+	// Only process reviews on Feedback PR
+	if !isFeedbackPR(submission, found, payload.Number, payload.PullRequest.Title) {
+		return webhookStatus("ignored"), nil
 	}
-
-	// Find submission by repo URL
-	var submission models.Submission
-	if err := database.DB.Preload("Student").Preload("Assignment.Course").Where("repo_url = ?", repoURL).First(&submission).Error; err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "submission_not_found"})
+	if !found {
+		return webhookStatus("submission_not_found"), nil
 	}
+	rememberFeedbackPR(&submission, payload.Number)
 
+	return h.finishReview(submission, reviewedOutcome(eventType, payload))
+}
+
+// finishReview completes the active review request of the submission with
+// a review left on its Feedback PR. Only members of the instructor team of
+// the course year finish reviews.
+func (h *WebhookHandler) finishReview(submission models.Submission, outcome models.ReviewEvent) (webhookResult, error) {
 	// Find active review request for this submission
 	var reviewRequest models.ReviewRequest
 	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
@@ -406,378 +357,128 @@ func (h *WebhookHandler) handleReviewed(c echo.Context, body []byte) error {
 		Order("created_at DESC").First(&reviewRequest).Error
 	if err != nil {
 		// No active review request
-		return c.JSON(http.StatusOK, map[string]string{"status": "no_active_review"})
+		return webhookStatus("no_active_review"), nil
 	}
 
-	// Restore student's write access using admin token
-	repoName := extractRepoName(submission.RepoURL)
-	orgName := submission.Assignment.Course.OrgName
-
-	if h.cfg.GiteaAdminToken != "" {
-		adminService, err := services.NewGiteaService(h.cfg.GiteaURL, h.cfg.GiteaAdminToken)
-		if err == nil {
-			// Restore Write access to student
-			if err := adminService.AddCollaborator(orgName, repoName, submission.Student.Username, gitea.AccessModeWrite); err != nil {
-				log.Printf("Warning: failed to restore student write access: %v", err)
-			} else {
-				log.Printf("Restored write access for student %s on %s/%s", submission.Student.Username, orgName, repoName)
-			}
-		}
+	// Get instructor token to check team membership
+	var instructor models.User
+	err = database.DB.
+		Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
+		Where("course_instructors.course_id = ?", submission.Assignment.CourseID).
+		First(&instructor).Error
+	if err != nil {
+		return webhookStatus("no_instructor"), nil
 	}
 
-	// Update review request status
-	now := time.Now()
-	reviewRequest.Status = models.ReviewStatusReviewed
-	reviewRequest.ReviewedAt = &now
-
-	if err := database.DB.Save(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update review request")
+	giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, instructor.AccessToken)
+	if err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize gitea service")
 	}
 
-	// Update Google Sheets status
-	if h.sheets != nil && reviewRequest.SheetRowID > 0 {
-		h.sheets.UpdateRowStatus(reviewRequest.SheetRowID, "Проверено")
+	// Check if reviewer is in instructor team
+	teamName := fmt.Sprintf("%d-%s-instructors", submission.Assignment.AcademicYear, submission.Assignment.Course.Slug)
+	isMember, err := giteaService.IsTeamMember(submission.Assignment.Course.OrgName, teamName, outcome.Actor)
+	if err != nil {
+		return webhookResult{}, fmt.Errorf("failed to check instructor team membership: %w", err)
 	}
-
-	log.Printf("Review completed via 'reviewed' action: ReviewRequest=%d, Submission=%d", reviewRequest.ID, submission.ID)
-
-	return c.JSON(http.StatusOK, map[string]string{
-		"status":            "processed",
-		"review_request_id": fmt.Sprintf("%d", reviewRequest.ID),
-	})
-}
-
-// Handle issue_comment events (magic command /review)
-func (h *WebhookHandler) handleIssueComment(c echo.Context, body []byte) error {
-	var payload GiteaIssueCommentPayload
-	if err := json.Unmarshal(body, &payload); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+	if !isMember {
+		// Reviewer is not an instructor, ignore
+		return webhookStatus("reviewer_not_instructor"), nil
 	}
 
-	log.Print(1)
-	// Only process created comments
-	if payload.Action != "created" {
-		return c.JSON(http.StatusOK, map[string]string{"status": "ignored"})
+	if err := h.completeReview(&reviewRequest, submission, outcome); err != nil {
+		return webhookResult{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to update review request")
 	}
-	log.Print(2)
 
-	// Only process comments on pull requests
-	if !payload.IsPull {
-		return c.JSON(http.StatusOK, map[string]string{"status": "not_pull_request"})
-	}
-	log.Print(3)
+	log.Printf("Review completed by %s (%s): ReviewRequest=%d, Submission=%d",
+		outcome.Actor, outcome.Reason, reviewRequest.ID, submission.ID)
 
-	// Only process Feedback PR
-	if payload.Issue.Title != "Feedback" {
-		return c.JSON(http.StatusOK, map[string]string{"status": "not_feedback_pr"})
-	}
-	log.Print(4)
-
-	// Check if comment contains magic command
-	commentBody := strings.TrimSpace(payload.Comment.Body)
-
-	// Handle different commands
-	switch commentBody {
-	case "/review", "@review":
-		return h.handleReviewCommand(c, payload)
-	case "/unreview":
-		return h.handleUnreviewCommand(c, payload)
-	case "/review_now":
-		return h.handleReviewNowCommand(c, payload)
-	case "/force_unreview":
-		return h.handleForceUnreviewCommand(c, payload)
-	default:
-		return c.JSON(http.StatusOK, map[string]string{"status": "not_review_command"})
-	}
+	return webhookStatus("processed").
+		with("review_request_id", fmt.Sprintf("%d", reviewRequest.ID)), nil
 }
 
-// Handle /review or @review command
-func (h *WebhookHandler) handleReviewCommand(c echo.Context, payload GiteaIssueCommentPayload) error {
-	// Find submission by repo URL
-	repoURL := payload.Repository.HTMLURL
-	var submission models.Submission
-	if err := database.DB.Preload("Student").Preload("Assignment.Course").Where("repo_url = ?", repoURL).First(&submission).Error; err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "submission_not_found"})
-	}
-
-	// Check if commenter is the student who owns this submission
-	commenterUsername := payload.Comment.User.Username
-	if commenterUsername == "" {
-		commenterUsername = payload.Comment.User.Login
-	}
-
-	if submission.Student.Username != commenterUsername {
-		return c.JSON(http.StatusOK, map[string]string{"status": "not_submission_owner"})
-	}
-
-	// Check for existing active review request
-	var existingRequest models.ReviewRequest
-	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
-		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).First(&existingRequest).Error
-	if err == nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "review_already_active"})
-	}
-
-	// Use admin token to manage repository access
-	if h.cfg.GiteaAdminToken != "" {
-		giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, h.cfg.GiteaAdminToken)
-		if err == nil {
-			repoName := extractRepoName(submission.RepoURL)
-			orgName := submission.Assignment.Course.OrgName
-
-			// Change student's access from Write to Read
-			if err := giteaService.AddCollaborator(orgName, repoName, submission.Student.Username, gitea.AccessModeRead); err != nil {
-				log.Printf("Warning: failed to change student access to read-only: %v", err)
-			} else {
-				log.Printf("Changed student %s access to read-only on %s/%s", submission.Student.Username, orgName, repoName)
-			}
-		}
+// reviewedOutcome extracts reviewer, verdict and review text from a Gitea
+// pull request review payload.
+func reviewedOutcome(eventType string, payload GiteaPullRequestPayload) models.ReviewEvent {
+	outcome := models.ReviewEvent{
+		Reason:  "Gitea " + eventType + " event",
+		Actor:   payload.Sender.Username,
+		Verdict: review.NormalizeVerdict(payload.Review.Type),
+		Body:    payload.Review.Content,
 	}
-
-	// Create review request
-	reviewRequest := models.ReviewRequest{
-		SubmissionID: submission.ID,
-		Status:       models.ReviewStatusPending,
-		RequestedAt:  time.Now(),
+	if outcome.Actor == "" {
+		outcome.Actor = payload.Sender.Login
 	}
-
-	if err := database.DB.Create(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create review request")
+	if outcome.Verdict == "" {
+		outcome.Verdict = review.NormalizeVerdict(eventType)
 	}
-
-	// Add to cache with TTL
-	ttl := time.Duration(h.cfg.ReviewPendingMinutes) * time.Minute
-	h.cache.Add(reviewRequest.ID, submission.ID, ttl)
-
-	log.Printf("Review request created: ID=%d, Submission=%d, TTL=%v minutes, Expires at=%v\n",
-		reviewRequest.ID, submission.ID, h.cfg.ReviewPendingMinutes, reviewRequest.RequestedAt.Add(ttl))
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"status":            "review_requested",
-		"review_request_id": reviewRequest.ID,
-		"cancel_deadline":   reviewRequest.RequestedAt.Add(ttl),
-	})
+	return outcome
 }
 
-// Handle /unreview command - cancel review while it's in cache
-func (h *WebhookHandler) handleUnreviewCommand(c echo.Context, payload GiteaIssueCommentPayload) error {
-	// Find submission by repo URL
-	repoURL := payload.Repository.HTMLURL
-	var submission models.Submission
-	if err := database.DB.Preload("Student").Preload("Assignment.Course").Where("repo_url = ?", repoURL).First(&submission).Error; err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "submission_not_found"})
-	}
-
-	// Check if commenter is the student who owns this submission
-	commenterUsername := payload.Comment.User.Username
-	if commenterUsername == "" {
-		commenterUsername = payload.Comment.User.Login
-	}
-
-	if submission.Student.Username != commenterUsername {
-		return c.JSON(http.StatusOK, map[string]string{"status": "not_submission_owner"})
-	}
-
-	// Find pending review request
-	var reviewRequest models.ReviewRequest
-	err := database.DB.Where("submission_id = ? AND status = ?", submission.ID, models.ReviewStatusPending).
-		First(&reviewRequest).Error
-	if err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "no_pending_review"})
-	}
-
-	// Check if still in cache (within cancel period)
-	if !h.cache.Exists(reviewRequest.ID) {
-		return c.JSON(http.StatusOK, map[string]string{"status": "review_already_submitted", "message": "Review has been submitted to Google Sheets and cannot be cancelled"})
-	}
-
-	// Remove from cache
-	h.cache.Remove(reviewRequest.ID)
-
-	// Update review request status
-	reviewRequest.Status = models.ReviewStatusCancelled
-	if err := database.DB.Save(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to cancel review request")
-	}
-
-	// Restore write access
-	if h.cfg.GiteaAdminToken != "" {
-		giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, h.cfg.GiteaAdminToken)
-		if err == nil {
-			repoName := extractRepoName(submission.RepoURL)
-			orgName := submission.Assignment.Course.OrgName
-
-			if err := giteaService.AddCollaborator(orgName, repoName, submission.Student.Username, gitea.AccessModeWrite); err != nil {
-				log.Printf("Warning: failed to restore student write access: %v", err)
-			} else {
-				log.Printf("Restored write access for student %s on %s/%s", submission.Student.Username, orgName, repoName)
-			}
-		}
-	}
-
-	log.Printf("Review request cancelled: ID=%d, Submission=%d", reviewRequest.ID, submission.ID)
-
-	return c.JSON(http.StatusOK, map[string]string{
-		"status":  "review_cancelled",
-		"message": "Review request cancelled, write access restored",
+// completeReview finishes the review request with the status matching the
+// verdict, the state machine unlocks the repository and updates the sinks.
+func (h *WebhookHandler) completeReview(reviewRequest *models.ReviewRequest, submission models.Submission, outcome models.ReviewEvent) error {
+	reviewRequest.Submission = submission
+	return h.reviews.Apply(reviewRequest, review.Transition{
+		To:            review.OutcomeStatus(outcome.Verdict),
+		Actor:         outcome.Actor,
+		Reason:        outcome.Reason,
+		Verdict:       outcome.Verdict,
+		Body:          outcome.Body,
+		GiteaReviewID: outcome.GiteaReviewID,
 	})
 }
 
-// Handle /review_now command - immediately submit to Google Sheets
-func (h *WebhookHandler) handleReviewNowCommand(c echo.Context, payload GiteaIssueCommentPayload) error {
-	// Find submission by repo URL
-	repoURL := payload.Repository.HTMLURL
+// findSubmission resolves the submission of a Gitea repository by its ID,
+// which survives renames of the organization or the Gitea domain. Submissions
+// without a recorded ID are matched by URL and get the ID stored.
+func findSubmission(repoID int64, repoURL string) (models.Submission, bool) {
 	var submission models.Submission
-	if err := database.DB.Preload("Student").Preload("Assignment.Course").Where("repo_url = ?", repoURL).First(&submission).Error; err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "submission_not_found"})
-	}
-
-	// Check if commenter is the student who owns this submission
-	commenterUsername := payload.Comment.User.Username
-	if commenterUsername == "" {
-		commenterUsername = payload.Comment.User.Login
-	}
-
-	if submission.Student.Username != commenterUsername {
-		return c.JSON(http.StatusOK, map[string]string{"status": "not_submission_owner"})
-	}
-
-	// Check for existing active review request
-	var existingRequest models.ReviewRequest
-	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
-		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted}).First(&existingRequest).Error
-	if err == nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "review_already_active"})
-	}
-
-	// Use admin token to block repository immediately
-	if h.cfg.GiteaAdminToken != "" {
-		giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, h.cfg.GiteaAdminToken)
+	if repoID != 0 {
+		err := database.DB.Preload("Student").Preload("Assignment.Course").
+			Where("gitea_repo_id = ?", repoID).First(&submission).Error
 		if err == nil {
-			repoName := extractRepoName(submission.RepoURL)
-			orgName := submission.Assignment.Course.OrgName
-
-			// Change student's access from Write to Read immediately
-			if err := giteaService.AddCollaborator(orgName, repoName, submission.Student.Username, gitea.AccessModeRead); err != nil {
-				log.Printf("Warning: failed to change student access to read-only: %v", err)
-			} else {
-				log.Printf("Changed student %s access to read-only on %s/%s (immediate)", submission.Student.Username, orgName, repoName)
+			if repoURL != "" && submission.RepoURL != repoURL {
+				database.DB.Model(&models.Submission{}).Where("id = ?", submission.ID).Update("repo_url", repoURL)
+				submission.RepoURL = repoURL
 			}
+			return submission, true
 		}
 	}
 
-	// Create review request and immediately submit to sheets
-	now := time.Now()
-	reviewRequest := models.ReviewRequest{
-		SubmissionID: submission.ID,
-		Status:       models.ReviewStatusSubmitted,
-		RequestedAt:  now,
-		SubmittedAt:  &now,
+	byURL := database.DB.Preload("Student").Preload("Assignment.Course").Where("repo_url = ?", repoURL)
+	if repoID != 0 {
+		byURL = byURL.Where("gitea_repo_id = 0")
 	}
-
-	if err := database.DB.Create(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create review request")
+	if err := byURL.First(&submission).Error; err != nil {
+		return submission, false
 	}
-
-	// Submit to Google Sheets immediately if available
-	if h.sheets != nil {
-		studentInfo := fmt.Sprintf("%s (%s)", submission.Student.FullName, submission.Student.Username)
-		repoURL := submission.RepoURL
-		rowID, err := h.sheets.AppendReviewRequest(studentInfo, repoURL, now)
-		if err != nil {
-			log.Printf("Warning: failed to add review to Google Sheets: %v", err)
-		} else {
-			reviewRequest.SheetRowID = rowID
-			database.DB.Save(&reviewRequest)
-			log.Printf("Review request immediately submitted to Google Sheets: ID=%d, Submission=%d, RowID=%d",
-				reviewRequest.ID, submission.ID, rowID)
-		}
+	if repoID != 0 {
+		database.DB.Model(&models.Submission{}).Where("id = ?", submission.ID).Update("gitea_repo_id", repoID)
+		submission.GiteaRepoID = repoID
 	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"status":            "review_submitted_immediately",
-		"review_request_id": reviewRequest.ID,
-		"message":           "Review request submitted immediately to Google Sheets",
-	})
+	return submission, true
 }
 
-// Handle /force_unreview command - admin command to cancel any review request
-func (h *WebhookHandler) handleForceUnreviewCommand(c echo.Context, payload GiteaIssueCommentPayload) error {
-	// Find submission by repo URL
-	repoURL := payload.Repository.HTMLURL
-	var submission models.Submission
-	if err := database.DB.Preload("Student").Preload("Assignment.Course").Where("repo_url = ?", repoURL).First(&submission).Error; err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "submission_not_found"})
-	}
-
-	// Check if commenter is an instructor for this course
-	commenterUsername := payload.Comment.User.Username
-	if commenterUsername == "" {
-		commenterUsername = payload.Comment.User.Login
-	}
-
-	// Get commenter user
-	var commenter models.User
-	if err := database.DB.Where("username = ?", commenterUsername).First(&commenter).Error; err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "commenter_not_found"})
-	}
-
-	// Check if commenter is instructor for this course
-	if !isInstructor(commenter.ID, submission.Assignment.CourseID) {
-		return c.JSON(http.StatusOK, map[string]string{
-			"status":  "forbidden",
-			"message": "Only instructors can use /force_unreview",
-		})
-	}
-
-	// Find any active review request (pending, submitted, or even reviewed)
-	var reviewRequest models.ReviewRequest
-	err := database.DB.Where("submission_id = ? AND status IN ?", submission.ID,
-		[]string{models.ReviewStatusPending, models.ReviewStatusSubmitted, models.ReviewStatusReviewed}).
-		Order("created_at DESC").First(&reviewRequest).Error
-	if err != nil {
-		return c.JSON(http.StatusOK, map[string]string{"status": "no_review_request"})
-	}
-
-	// Remove from cache if exists
-	h.cache.Remove(reviewRequest.ID)
-
-	// Update review request status
-	reviewRequest.Status = models.ReviewStatusCancelled
-	if err := database.DB.Save(&reviewRequest).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to cancel review request")
-	}
-
-	// Restore write access using admin token
-	if h.cfg.GiteaAdminToken != "" {
-		giteaService, err := services.NewGiteaService(h.cfg.GiteaURL, h.cfg.GiteaAdminToken)
-		if err == nil {
-			repoName := extractRepoName(submission.RepoURL)
-			orgName := submission.Assignment.Course.OrgName
-
-			if err := giteaService.AddCollaborator(orgName, repoName, submission.Student.Username, gitea.AccessModeWrite); err != nil {
-				log.Printf("Warning: failed to restore student write access: %v", err)
-			} else {
-				log.Printf("Restored write access for student %s on %s/%s (forced by instructor %s)",
-					submission.Student.Username, orgName, repoName, commenterUsername)
-			}
-		}
+// isFeedbackPR reports whether the pull request is the submission's
+// Feedback PR. The title is only trusted while the PR number is unknown.
+func isFeedbackPR(submission models.Submission, found bool, number int64, title string) bool {
+	if found && submission.FeedbackPRNumber != 0 {
+		return number == submission.FeedbackPRNumber
 	}
+	return title == "Feedback"
+}
 
-	// Remove from Google Sheets if it was submitted
-	if h.sheets != nil && reviewRequest.SheetRowID > 0 {
-		if err := h.sheets.DeleteRow(reviewRequest.SheetRowID); err != nil {
-			log.Printf("Warning: failed to remove from Google Sheets: %v", err)
-		}
+// rememberFeedbackPR stores the Feedback PR number the first time a webhook
+// for it arrives, so the LMS can link to it.
+func rememberFeedbackPR(submission *models.Submission, number int64) {
+	if number == 0 || submission.FeedbackPRNumber != 0 {
+		return
 	}
-
-	log.Printf("Review request force-cancelled by instructor %s: ID=%d, Submission=%d, Status was=%s",
-		commenterUsername, reviewRequest.ID, submission.ID, reviewRequest.Status)
-
-	return c.JSON(http.StatusOK, map[string]string{
-		"status":  "force_cancelled",
-		"message": fmt.Sprintf("Review request cancelled by instructor %s, write access restored", commenterUsername),
-	})
+	database.DB.Model(&models.Submission{}).
+		Where("id = ? AND feedback_pr_number = 0", submission.ID).
+		Update("feedback_pr_number", number)
+	submission.FeedbackPRNumber = number
 }
 
 func verifySignature(body []byte, signature, secret string) bool {
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	webhookStatusInvalidSignature = "invalid_signature"
+	webhookStatusError            = "error"
+	webhookStatusDuplicate        = "duplicate"
+
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+// JobKindWebhook is the job handling a stored webhook delivery.
+const JobKindWebhook = "webhook"
+
+type webhookJob struct {
+	DeliveryID uint `json:"delivery_id"`
+}
+
+// ProcessDeliveryJob handles the delivery of a webhook job. Deliveries Gitea
+// sent wrong are not retried.
+func (h *WebhookHandler) ProcessDeliveryJob(job *models.Job) error {
+	var payload webhookJob
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	var delivery models.WebhookDelivery
+	if err := database.DB.First(&delivery, payload.DeliveryID).Error; err != nil {
+		return err
+	}
+
+	_, err := h.processDelivery(&delivery)
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Code < http.StatusInternalServerError {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// newWebhookDelivery builds the log entry of an incoming webhook.
+func newWebhookDelivery(eventType, deliveryID string, body []byte) *models.WebhookDelivery {
+	var payload struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	_ = json.Unmarshal(body, &payload)
+
+	return &models.WebhookDelivery{
+		Event:      eventType,
+		DeliveryID: deliveryID,
+		Repo:       payload.Repository.FullName,
+		Body:       string(body),
+		ReceivedAt: time.Now(),
+	}
+}
+
+// saveWebhookDelivery stores the delivery before it is handled. The delivery
+// log never blocks a webhook, failures are only logged.
+func saveWebhookDelivery(delivery *models.WebhookDelivery) {
+	if err := database.DB.Create(delivery).Error; err != nil {
+		log.Printf("Warning: failed to store webhook delivery %s: %v", delivery.DeliveryID, err)
+	}
+}
+
+// finishWebhookDelivery records the outcome of handling the delivery.
+func finishWebhookDelivery(delivery *models.WebhookDelivery, result webhookResult, err error) {
+	delivery.Status = result.Status
+	delivery.HTTPStatus = http.StatusOK
+	delivery.Error = ""
+	if err != nil {
+		if delivery.Status == "" {
+			delivery.Status = webhookStatusError
+		}
+		delivery.HTTPStatus = http.StatusInternalServerError
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			delivery.HTTPStatus = httpErr.Code
+		}
+		delivery.Error = err.Error()
+	} else if response, err := json.Marshal(result.response()); err == nil {
+		delivery.Response = string(response)
+	}
+
+	if delivery.ID == 0 {
+		return
+	}
+	err = database.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":      delivery.Status,
+		"http_status": delivery.HTTPStatus,
+		"response":    delivery.Response,
+		"error":       delivery.Error,
+	}).Error
+	if err != nil {
+		log.Printf("Warning: failed to record outcome of webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// processDelivery handles a saved delivery and records the outcome. A
+// delivery of an action that was already handled is not run again, it gets
+// the outcome recorded for the first one.
+func (h *WebhookHandler) processDelivery(delivery *models.WebhookDelivery) (webhookResult, error) {
+	if original := claimWebhookDelivery(delivery); original != nil {
+		return finishDuplicateDelivery(delivery, original), nil
+	}
+
+	result, err := h.process(delivery.Event, []byte(delivery.Body), delivery.ReceivedAt)
+	if err != nil && delivery.DedupeKey != nil {
+		// Let a retry of the failed delivery run the action again
+		if err := database.DB.Model(delivery).Update("dedupe_key", nil).Error; err != nil {
+			log.Printf("Warning: failed to release webhook delivery %d: %v", delivery.ID, err)
+		}
+		delivery.DedupeKey = nil
+	}
+	finishWebhookDelivery(delivery, result, err)
+
+	log.Printf("Webhook %s (delivery %s, %s): status=%s error=%v",
+		delivery.Event, delivery.DeliveryID, delivery.Repo, delivery.Status, err)
+	return result, err
+}
+
+// dedupeKey identifies the action a delivery triggers. Gitea gives
+// redelivered webhooks a new delivery ID, so comments are keyed on the
+// comment they were written for.
+func dedupeKey(delivery *models.WebhookDelivery) string {
+	if delivery.Event == "issue_comment" {
+		var payload GiteaIssueCommentPayload
+		if err := json.Unmarshal([]byte(delivery.Body), &payload); err == nil &&
+			payload.Action == "created" && payload.Comment.ID != 0 {
+			return fmt.Sprintf("comment:%d", payload.Comment.ID)
+		}
+	}
+	if delivery.DeliveryID != "" {
+		return "delivery:" + delivery.DeliveryID
+	}
+	return ""
+}
+
+// claimWebhookDelivery stores the dedupe key on the delivery. The unique
+// index lets only one delivery hold a key, the holder is returned when it is
+// another delivery.
+func claimWebhookDelivery(delivery *models.WebhookDelivery) *models.WebhookDelivery {
+	key := dedupeKey(delivery)
+	if key == "" || delivery.ID == 0 {
+		return nil
+	}
+
+	err := database.DB.Model(delivery).Update("dedupe_key", key).Error
+	if err == nil {
+		delivery.DedupeKey = &key
+		return nil
+	}
+
+	var original models.WebhookDelivery
+	if database.DB.Where("dedupe_key = ?", key).First(&original).Error != nil {
+		log.Printf("Warning: failed to claim webhook delivery %d: %v", delivery.ID, err)
+		return nil
+	}
+	return &original
+}
+
+// handledDelivery returns the delivery that already handled the action of
+// this one, nil when there is none or it has no outcome yet.
+func handledDelivery(delivery *models.WebhookDelivery) *models.WebhookDelivery {
+	key := dedupeKey(delivery)
+	if key == "" {
+		return nil
+	}
+
+	var original models.WebhookDelivery
+	if database.DB.Where("dedupe_key = ? AND response <> ''", key).First(&original).Error != nil {
+		return nil
+	}
+	return &original
+}
+
+// finishDuplicateDelivery records the delivery as a duplicate and returns the
+// outcome of the original. An original still being handled has none yet.
+func finishDuplicateDelivery(delivery, original *models.WebhookDelivery) webhookResult {
+	result := webhookStatus(webhookStatusDuplicate).with("original_delivery_id", original.ID)
+	if original.Response != "" {
+		var response map[string]interface{}
+		if err := json.Unmarshal([]byte(original.Response), &response); err == nil {
+			result = webhookStatus(fmt.Sprint(response["status"]))
+			for k, v := range response {
+				if k != "status" {
+					result = result.with(k, v)
+				}
+			}
+		}
+	}
+
+	delivery.Status = webhookStatusDuplicate
+	delivery.HTTPStatus = http.StatusOK
+	delivery.DuplicateOf = &original.ID
+	if response, err := json.Marshal(result.response()); err == nil {
+		delivery.Response = string(response)
+	}
+	err := database.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":       delivery.Status,
+		"http_status":  delivery.HTTPStatus,
+		"response":     delivery.Response,
+		"duplicate_of": original.ID,
+	}).Error
+	if err != nil {
+		log.Printf("Warning: failed to record duplicate webhook delivery %d: %v", delivery.ID, err)
+	}
+
+	log.Printf("Webhook %s (delivery %s, %s) duplicates delivery %d, skipped",
+		delivery.Event, delivery.DeliveryID, delivery.Repo, original.ID)
+	return result
+}
+
+// ListWebhookDeliveries searches the delivery log, newest first. Bodies are
+// left out, GetWebhookDelivery returns them.
+func (h *WebhookHandler) ListWebhookDeliveries(c echo.Context) error {
+	if !isAdmin(c.Get("user_id").(uint)) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can view webhook deliveries")
+	}
+
+	query := database.DB.Model(&models.WebhookDelivery{}).Omit("body")
+	if event := c.QueryParam("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+	if deliveryID := c.QueryParam("delivery_id"); deliveryID != "" {
+		query = query.Where("delivery_id = ?", deliveryID)
+	}
+	if repo := c.QueryParam("repo"); repo != "" {
+		query = query.Where("repo = ?", repo)
+	}
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if c.QueryParam("failed") == "true" {
+		query = query.Where("error <> ''")
+	}
+	if since := c.QueryParam("since"); since != "" {
+		t, err := parseDateTime(since)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid since")
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if until := c.QueryParam("until"); until != "" {
+		t, err := parseDateTime(until)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid until")
+		}
+		query = query.Where("created_at < ?", t)
+	}
+
+	limit := defaultDeliveryLimit
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		if n > maxDeliveryLimit {
+			n = maxDeliveryLimit
+		}
+		limit = n
+	}
+	offset := 0
+	if o := c.QueryParam("offset"); o != "" {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid offset")
+		}
+		offset = n
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch webhook deliveries")
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *WebhookHandler) GetWebhookDelivery(c echo.Context) error {
+	if !isAdmin(c.Get("user_id").(uint)) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can view webhook deliveries")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid delivery id")
+	}
+
+	var delivery models.WebhookDelivery
+	if err := database.DB.First(&delivery, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "webhook delivery not found")
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+// ReplayWebhookDelivery runs a stored delivery through the webhook handlers
+// again and logs the replay as a new delivery. It bypasses the job queue so
+// the outcome can be returned. Like a Gitea redelivery, only deliveries that
+// failed are handled again, others return their outcome.
+func (h *WebhookHandler) ReplayWebhookDelivery(c echo.Context) error {
+	if !isAdmin(c.Get("user_id").(uint)) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can replay webhook deliveries")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid delivery id")
+	}
+
+	var original models.WebhookDelivery
+	if err := database.DB.First(&original, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "webhook delivery not found")
+	}
+
+	// Such bodies were never verified to come from Gitea
+	if original.Status == webhookStatusInvalidSignature {
+		return echo.NewHTTPError(http.StatusBadRequest, "cannot replay a delivery with an invalid signature")
+	}
+
+	delivery := newWebhookDelivery(original.Event, original.DeliveryID, []byte(original.Body))
+	delivery.ReplayOf = &original.ID
+	if !original.ReceivedAt.IsZero() {
+		delivery.ReceivedAt = original.ReceivedAt
+	}
+	saveWebhookDelivery(delivery)
+
+	log.Printf("Replaying webhook delivery %d as %d", original.ID, delivery.ID)
+
+	// The replay outcome is returned as recorded, errors included
+	_, _ = h.processDelivery(delivery)
+
+	return c.JSON(http.StatusOK, delivery)
+}
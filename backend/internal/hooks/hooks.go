@@ -0,0 +1,269 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+)
+
+// Events are the Gitea hook events HandleGiteaWebhook needs. Gitea subscribes
+// pull request comments as pull_request_comment but delivers them with
+// X-Gitea-Event issue_comment, so both are listed. Reviews submitted as a
+// comment are delivered as pull_request_comment with a pull request payload.
+var Events = []string{
+	"push",
+	"pull_request",
+	"pull_request_review_request",
+	"pull_request_review_approved",
+	"pull_request_review_rejected",
+	"pull_request_review_comment",
+	"pull_request_comment",
+	"issue_comment",
+	"status",
+	"workflow_run",
+}
+
+// Hook actions reported by the reconciliation
+const (
+	ActionOK      = "ok"
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionRemoved = "removed"
+)
+
+// ErrDisabled is returned when no webhook URL or secret is configured.
+var ErrDisabled = errors.New("webhooks are not configured")
+
+// Report is the outcome of reconciling the hooks of a course.
+type Report struct {
+	OrgHook string   `json:"org_hook"`
+	Repos   int      `json:"repos"`
+	Created int      `json:"created"`
+	Updated int      `json:"updated"`
+	Removed int      `json:"removed"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// Manager keeps the LMS webhook registered on course organizations, or on
+// the submission repositories when the organization hook cannot be used.
+type Manager struct {
+	cfg *config.Config
+}
+
+func NewManager(cfg *config.Config) *Manager {
+	return &Manager{cfg: cfg}
+}
+
+// Enabled reports whether a webhook URL and secret are configured.
+func (m *Manager) Enabled() bool {
+	return m.cfg.WebhookBaseURL != "" && m.cfg.GiteaWebhookSecret != ""
+}
+
+// URL is the address Gitea delivers webhooks to.
+func (m *Manager) URL() string {
+	return fmt.Sprintf("%s/api/webhooks/gitea", m.cfg.WebhookBaseURL)
+}
+
+func (m *Manager) giteaService() (*services.GiteaService, error) {
+	if !m.Enabled() {
+		return nil, ErrDisabled
+	}
+	if m.cfg.GiteaAdminToken == "" {
+		return nil, errors.New("Gitea admin token not configured")
+	}
+	return services.NewGiteaService(m.cfg.GiteaURL, m.cfg.GiteaAdminToken)
+}
+
+// EnsureOrgHook registers the webhook on the organization, or fixes the
+// existing one.
+func (m *Manager) EnsureOrgHook(orgName string) (string, error) {
+	giteaService, err := m.giteaService()
+	if err != nil {
+		return "", err
+	}
+	return m.ensureOrgHook(giteaService, orgName)
+}
+
+func (m *Manager) ensureOrgHook(giteaService *services.GiteaService, orgName string) (string, error) {
+	existing, err := giteaService.ListOrgWebhooks(orgName)
+	if err != nil {
+		return "", err
+	}
+
+	for _, hook := range m.own(existing) {
+		if m.matches(hook) {
+			return ActionOK, nil
+		}
+		if err := giteaService.EditOrgWebhook(orgName, hook.ID, m.URL(), m.cfg.GiteaWebhookSecret, Events); err != nil {
+			return "", err
+		}
+		log.Printf("Updated webhook %d of organization %s", hook.ID, orgName)
+		return ActionUpdated, nil
+	}
+
+	hook, err := giteaService.CreateOrgWebhook(orgName, m.URL(), m.cfg.GiteaWebhookSecret, Events)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Created webhook %d on organization %s", hook.ID, orgName)
+	return ActionCreated, nil
+}
+
+// EnsureRepoHook makes sure the repository receives webhooks exactly once:
+// from the organization hook when it is in place, otherwise from a single
+// repository hook.
+func (m *Manager) EnsureRepoHook(orgName, repoName string) (string, error) {
+	giteaService, err := m.giteaService()
+	if err != nil {
+		return "", err
+	}
+
+	orgHooks, err := giteaService.ListOrgWebhooks(orgName)
+	if err != nil {
+		return "", err
+	}
+	return m.reconcileRepo(giteaService, orgName, repoName, m.hasOrgHook(orgHooks))
+}
+
+// ReconcileCourse ensures the organization hook of the course and checks
+// the hooks of every submission repository. Failed repositories are
+// reported and do not stop the run.
+func (m *Manager) ReconcileCourse(course *models.Course) (*Report, error) {
+	giteaService, err := m.giteaService()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	orgHook := true
+	report.OrgHook, err = m.ensureOrgHook(giteaService, course.OrgName)
+	if err != nil {
+		// Fall back to repository hooks, e.g. without organization owner rights
+		log.Printf("Warning: failed to register webhook on organization %s: %v", course.OrgName, err)
+		report.OrgHook = "failed: " + err.Error()
+		orgHook = false
+	}
+
+	var repoURLs []string
+	err = database.DB.Model(&models.Submission{}).
+		Joins("JOIN assignments ON assignments.id = submissions.assignment_id").
+		Where("assignments.course_id = ? AND assignments.deleted_at IS NULL AND submissions.repo_url <> ''", course.ID).
+		Pluck("submissions.repo_url", &repoURLs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, repoURL := range repoURLs {
+		repoName := review.RepoNameFromURL(repoURL)
+		report.Repos++
+
+		action, err := m.reconcileRepo(giteaService, course.OrgName, repoName, orgHook)
+		if err != nil {
+			log.Printf("Warning: failed to reconcile webhook of %s/%s: %v", course.OrgName, repoName, err)
+			report.Failed = append(report.Failed, repoName)
+			continue
+		}
+		switch action {
+		case ActionCreated:
+			report.Created++
+		case ActionUpdated:
+			report.Updated++
+		case ActionRemoved:
+			report.Removed++
+		}
+	}
+
+	return report, nil
+}
+
+// reconcileRepo removes the LMS hooks of the repository when the
+// organization hook covers it, so deliveries are not doubled. Otherwise it
+// keeps exactly one correct repository hook.
+func (m *Manager) reconcileRepo(giteaService *services.GiteaService, owner, repo string, orgHook bool) (string, error) {
+	existing, err := giteaService.ListRepoWebhooks(owner, repo)
+	if err != nil {
+		return "", err
+	}
+	own := m.own(existing)
+
+	keep := 0
+	if orgHook {
+		keep = -1
+	}
+
+	action := ActionOK
+	for i, hook := range own {
+		if i > keep {
+			if err := giteaService.DeleteRepoWebhook(owner, repo, hook.ID); err != nil {
+				return "", err
+			}
+			action = ActionRemoved
+			continue
+		}
+		if !m.matches(hook) {
+			if err := giteaService.EditRepoWebhook(owner, repo, hook.ID, m.URL(), m.cfg.GiteaWebhookSecret, Events); err != nil {
+				return "", err
+			}
+			action = ActionUpdated
+		}
+	}
+
+	if !orgHook && len(own) == 0 {
+		if _, err := giteaService.CreateRepoWebhook(owner, repo, m.URL(), m.cfg.GiteaWebhookSecret, Events); err != nil {
+			return "", err
+		}
+		action = ActionCreated
+	}
+
+	if action != ActionOK {
+		log.Printf("Webhook of %s/%s %s", owner, repo, action)
+	}
+	return action, nil
+}
+
+// own returns the hooks delivering to the LMS.
+func (m *Manager) own(hooks []*gitea.Hook) []*gitea.Hook {
+	var own []*gitea.Hook
+	for _, hook := range hooks {
+		if hook.Config["url"] == m.URL() {
+			own = append(own, hook)
+		}
+	}
+	return own
+}
+
+func (m *Manager) hasOrgHook(hooks []*gitea.Hook) bool {
+	for _, hook := range m.own(hooks) {
+		if m.matches(hook) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the hook is active, sends JSON and subscribes to
+// exactly the needed events.
+func (m *Manager) matches(hook *gitea.Hook) bool {
+	if !hook.Active || hook.Config["content_type"] != "json" || len(hook.Events) != len(Events) {
+		return false
+	}
+
+	got := append([]string(nil), hook.Events...)
+	want := append([]string(nil), Events...)
+	sort.Strings(got)
+	sort.Strings(want)
+	for i := range want {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
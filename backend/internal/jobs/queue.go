@@ -0,0 +1,318 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = time.Hour
+)
+
+// StaleAfter is how long a job may run. Running jobs locked for longer are
+// assumed lost with their server and queued again.
+const StaleAfter = 15 * time.Minute
+
+// ErrNotDead is returned when retrying a job that is not dead.
+var ErrNotDead = errors.New("job is not dead")
+
+// Handler runs a job of one kind. A returned error queues the job again
+// unless it is Permanent or the job is out of attempts.
+type Handler func(job *models.Job) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix, the job goes dead at once.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsLastAttempt reports whether the job goes dead when this attempt fails
+// with err.
+func IsLastAttempt(job *models.Job, err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts
+}
+
+// Queue is a job queue stored in Postgres. Any number of workers, also in
+// other server processes, may claim jobs from it through its pools.
+type Queue struct {
+	maxAttempts int
+	shared      *Pool
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	pools    map[string]*Pool
+}
+
+func NewQueue(cfg *config.Config) *Queue {
+	q := &Queue{
+		maxAttempts: cfg.JobMaxAttempts,
+		handlers:    make(map[string]Handler),
+		pools:       make(map[string]*Pool),
+	}
+	q.shared = &Pool{queue: q, wake: make(chan struct{}, 1)}
+	return q
+}
+
+// Pool is the set of workers claiming some job kinds, so slow kinds cannot
+// hold up the others.
+type Pool struct {
+	queue *Queue
+	kind  string // empty for the shared pool
+	wake  chan struct{}
+}
+
+// Pool returns the pool of a job kind, which the shared pool leaves alone.
+// Pools are set up before workers start.
+func (q *Queue) Pool(kind string) *Pool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pool, ok := q.pools[kind]
+	if !ok {
+		pool = &Pool{queue: q, kind: kind, wake: make(chan struct{}, 1)}
+		q.pools[kind] = pool
+	}
+	return pool
+}
+
+// Shared returns the pool running the kinds without a pool of their own.
+func (q *Queue) Shared() *Pool {
+	return q.shared
+}
+
+// poolOf returns the pool running jobs of the kind.
+func (q *Queue) poolOf(kind string) *Pool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if pool, ok := q.pools[kind]; ok {
+		return pool
+	}
+	return q.shared
+}
+
+// notifyAll wakes an idle worker of every pool.
+func (q *Queue) notifyAll() {
+	q.shared.Notify()
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	for _, pool := range q.pools {
+		pool.Notify()
+	}
+}
+
+// Register sets the handler of a job kind.
+func (q *Queue) Register(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Enqueue stores a job with the JSON encoded payload and wakes a worker.
+func (q *Queue) Enqueue(kind string, payload interface{}) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.Job{
+		Kind:        kind,
+		Payload:     string(data),
+		State:       models.JobStateQueued,
+		MaxAttempts: q.maxAttempts,
+		RunAt:       time.Now(),
+	}
+	if err := database.DB.Create(job).Error; err != nil {
+		return nil, err
+	}
+
+	q.poolOf(kind).Notify()
+	return job, nil
+}
+
+func (p *Pool) String() string {
+	if p.kind == "" {
+		return "shared"
+	}
+	return p.kind
+}
+
+// Notify wakes one idle worker of the pool.
+func (p *Pool) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wake receives when there may be jobs for the pool to run.
+func (p *Pool) Wake() <-chan struct{} {
+	return p.wake
+}
+
+// Run executes a job claimed by the pool.
+func (p *Pool) Run(job *models.Job) {
+	p.queue.Run(job)
+}
+
+// RecoverStale queues running jobs again whose worker went away.
+func (p *Pool) RecoverStale() (requeued, dead int64, err error) {
+	return p.queue.RecoverStale()
+}
+
+// Claim locks the next due job of the pool for the caller, nil when there
+// is none.
+func (p *Pool) Claim() (*models.Job, error) {
+	kinds, match := []string{p.kind}, "IN"
+	if p.kind == "" {
+		kinds, match = p.queue.ownPools(), "NOT IN"
+	}
+
+	var job models.Job
+	now := time.Now()
+	err := database.DB.Raw(`UPDATE jobs SET state = ?, locked_at = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = (
+			SELECT id FROM jobs WHERE state = ? AND run_at <= ? AND kind `+match+` ?
+			ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED
+		) RETURNING *`,
+		models.JobStateRunning, now, now, models.JobStateQueued, now, kinds).Scan(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	if job.ID == 0 {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+// ownPools lists the kinds with a pool of their own. No job has the empty
+// kind, it keeps the list from being empty in NOT IN.
+func (q *Queue) ownPools() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	kinds := []string{""}
+	for kind := range q.pools {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// Run executes a claimed job and stores the outcome.
+func (q *Queue) Run(job *models.Job) {
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Kind]
+	q.mu.RUnlock()
+
+	var err error
+	if ok {
+		err = runHandler(handler, job)
+	} else {
+		err = Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"locked_at": nil}
+	switch {
+	case err == nil:
+		updates["state"] = models.JobStateDone
+		updates["finished_at"] = now
+		updates["last_error"] = ""
+	case IsLastAttempt(job, err):
+		updates["state"] = models.JobStateDead
+		updates["finished_at"] = now
+		updates["last_error"] = err.Error()
+		log.Printf("Job %d (%s) is dead after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+	default:
+		delay := Backoff(job.Attempts)
+		updates["state"] = models.JobStateQueued
+		updates["run_at"] = now.Add(delay)
+		updates["last_error"] = err.Error()
+		log.Printf("Job %d (%s) failed, attempt %d/%d, retrying in %v: %v", job.ID, job.Kind, job.Attempts, job.MaxAttempts, delay, err)
+	}
+
+	result := database.DB.Model(&models.Job{}).Where("id = ? AND state = ?", job.ID, models.JobStateRunning).Updates(updates)
+	if result.Error != nil {
+		log.Printf("Failed to store outcome of job %d: %v", job.ID, result.Error)
+	}
+}
+
+// runHandler turns a panicking handler into a failed attempt.
+func runHandler(handler Handler, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(job)
+}
+
+// Backoff is the delay before the next attempt after the given number of
+// attempts, doubling from 30 seconds up to an hour.
+func Backoff(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// RecoverStale queues running jobs again whose worker went away. Jobs out
+// of attempts go dead instead, a job taking its worker down with it would
+// be retried forever otherwise.
+func (q *Queue) RecoverStale() (requeued, dead int64, err error) {
+	now := time.Now()
+	stale := database.DB.Model(&models.Job{}).
+		Where("state = ? AND locked_at < ?", models.JobStateRunning, now.Add(-StaleAfter))
+
+	result := stale.Session(&gorm.Session{}).Where("attempts >= max_attempts").
+		Updates(map[string]interface{}{
+			"state":       models.JobStateDead,
+			"locked_at":   nil,
+			"finished_at": now,
+			"last_error":  fmt.Sprintf("worker lost for more than %v on the last attempt", StaleAfter),
+		})
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	dead = result.RowsAffected
+
+	result = stale.Session(&gorm.Session{}).
+		Updates(map[string]interface{}{"state": models.JobStateQueued, "locked_at": nil, "run_at": now})
+	return result.RowsAffected, dead, result.Error
+}
+
+// Retry queues a dead job again with fresh attempts.
+func (q *Queue) Retry(id uint) error {
+	result := database.DB.Model(&models.Job{}).Where("id = ? AND state = ?", id, models.JobStateDead).
+		Updates(map[string]interface{}{
+			"state":       models.JobStateQueued,
+			"attempts":    0,
+			"run_at":      time.Now(),
+			"finished_at": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotDead
+	}
+
+	q.notifyAll()
+	return nil
+}
@@ -2,39 +2,123 @@ package middleware
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
+// ScopeReviewEvents limits a token to the review event stream of one
+// submission
+const ScopeReviewEvents = "review_events"
+
+// StreamTokenTTL is how long a stream token can be used to connect
+const StreamTokenTTL = 5 * time.Minute
+
+// streamTokenParam is the query parameter carrying stream tokens
+const streamTokenParam = "access_token"
+
 type JWTClaims struct {
 	UserID uint `json:"user_id"`
+
+	// Set on stream tokens only, session tokens have no scope
+	Scope        string `json:"scope,omitempty"`
+	SubmissionID uint   `json:"submission_id,omitempty"`
+
 	jwt.RegisteredClaims
 }
 
+// AuthMiddleware accepts session tokens in the Authorization header.
 func AuthMiddleware(secret string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
-			if authHeader == "" {
+			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+			if tokenString == "" {
 				return echo.NewHTTPError(http.StatusUnauthorized, "missing authorization header")
 			}
 
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+			claims, err := parseToken(secret, tokenString)
+			if err != nil || claims.Scope != "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+			}
 
-			token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return []byte(secret), nil
-			})
+			c.Set("user_id", claims.UserID)
+
+			return next(c)
+		}
+	}
+}
+
+// StreamAuthMiddleware guards the review event stream of the :id
+// submission. EventSource cannot send headers, so besides a session token
+// in the header it accepts a stream token of that submission in the query.
+func StreamAuthMiddleware(secret string) echo.MiddlewareFunc {
+	session := AuthMiddleware(secret)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withSession := session(next)
+		return func(c echo.Context) error {
+			tokenString := c.QueryParam(streamTokenParam)
+			if tokenString == "" {
+				return withSession(c)
+			}
 
-			if err != nil || !token.Valid {
+			claims, err := parseToken(secret, tokenString)
+			if err != nil || claims.Scope != ScopeReviewEvents ||
+				strconv.FormatUint(uint64(claims.SubmissionID), 10) != c.Param("id") {
 				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
 			}
 
-			claims := token.Claims.(*JWTClaims)
 			c.Set("user_id", claims.UserID)
 
 			return next(c)
 		}
 	}
 }
+
+// NewStreamToken signs a short lived token for the review event stream of
+// the submission.
+func NewStreamToken(secret string, userID, submissionID uint) (string, time.Time, error) {
+	expiresAt := time.Now().Add(StreamTokenTTL)
+	claims := &JWTClaims{
+		UserID:       userID,
+		Scope:        ScopeReviewEvents,
+		SubmissionID: submissionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	return token, expiresAt, err
+}
+
+// RedactTokens hides query tokens from the request URI the request logger
+// writes out. Handlers read the query from the URL, which is left alone.
+func RedactTokens() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if query := req.URL.Query(); query.Has(streamTokenParam) {
+				query.Set(streamTokenParam, "REDACTED")
+				redacted := url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: query.Encode()}
+				req.RequestURI = redacted.RequestURI()
+			}
+			return next(c)
+		}
+	}
+}
+
+func parseToken(secret, tokenString string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	if err != nil || !token.Valid {
+		return nil, echo.ErrUnauthorized
+	}
+	return claims, nil
+}
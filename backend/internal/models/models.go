@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -37,6 +38,18 @@ type Course struct {
 	AcademicYear int    `json:"academic_year"`
 	InviteCode   string `gorm:"uniqueIndex" json:"invite_code"`
 
+	// Comma separated review sink names, empty means the server default
+	ReviewSinks string `json:"review_sinks"`
+	// Reviewer assignment strategy, empty means the server default
+	ReviewerAssignment string `json:"reviewer_assignment"`
+	// Repository lock strategy during review, empty means the server default
+	RepoLockStrategy string `json:"repo_lock_strategy"`
+	// Days a submitted review may wait, 0 means the server default and a
+	// negative value disables the SLA
+	ReviewSLADays int `json:"review_sla_days"`
+	// Comma separated Feedback PR commands turned off in the course
+	DisabledCommands string `json:"disabled_commands"`
+
 	Instructors []User       `gorm:"many2many:course_instructors;" json:"instructors,omitempty"`
 	Assignments []Assignment `json:"assignments,omitempty"`
 	Students    []Student    `json:"students,omitempty"`
@@ -58,6 +71,28 @@ type Assignment struct {
 	MaxPoints    int       `json:"max_points"`
 	AcademicYear int       `json:"academic_year"`
 
+	// Autograding, off while GradingCommand is empty. Limits are in seconds
+	// and megabytes, 0 means the default.
+	GradingCommand     string `gorm:"type:text" json:"grading_command"`
+	GradingTimeLimit   int    `json:"grading_time_limit"`
+	GradingMemoryLimit int    `json:"grading_memory_limit"`
+	GradeOnPush        bool   `json:"grade_on_push"`
+	GradeAtDeadline    bool   `json:"grade_at_deadline"`
+	// How the grading output becomes points: exit_code, junit, tap or
+	// autograding, with the result file glob for junit and tap
+	GradingResultFormat string `json:"grading_result_format"`
+	GradingResultPath   string `json:"grading_result_path"`
+
+	// Private repository of the course organization with hidden tests,
+	// overlaid onto the submission only while grading. TestsRef is the
+	// branch, tag or SHA used, the default branch when empty.
+	TestsRepo string `json:"tests_repo"`
+	TestsRef  string `json:"tests_ref"`
+
+	// Time the deadline was handled, reset when the deadline moves
+	DeadlineProcessedAt *time.Time `json:"deadline_processed_at,omitempty"`
+
+	Rubric      *Rubric      `json:"rubric,omitempty"`
 	Submissions []Submission `json:"submissions,omitempty"`
 }
 
@@ -94,6 +129,49 @@ type Submission struct {
 	Score       *int       `json:"score"`
 	Feedback    string     `json:"feedback"`
 	SubmittedAt *time.Time `json:"submitted_at"`
+
+	// Gitea IDs the webhooks are matched by, 0 until they are known
+	GiteaRepoID      int64 `gorm:"index" json:"gitea_repo_id"`
+	FeedbackPRNumber int64 `json:"feedback_pr_number"`
+
+	// Outcome of the latest finished review, empty until the first one
+	ReviewOutcome string `json:"review_outcome"`
+
+	// Time the latest push was received, and the default branch head
+	LastPushAt *time.Time `json:"last_push_at"`
+	HeadSHA    string     `json:"head_sha"`
+
+	// Combined check state of CISHA, the head commit or the latest checked
+	// one while the head is unknown. Empty until a check is reported.
+	CIState string        `json:"ci_state"`
+	CISHA   string        `json:"ci_sha"`
+	Checks  []CommitCheck `json:"checks,omitempty"`
+
+	// Outcome of the latest finished autograding run
+	AutogradeRunID  *uint      `json:"autograde_run_id"`
+	AutogradeStatus string     `json:"autograde_status"`
+	AutogradePoints *int       `json:"autograde_points"`
+	AutogradedAt    *time.Time `json:"autograded_at"`
+
+	// Head of main when the assignment deadline passed, and the time the
+	// deadline tag was set on it
+	DeadlineSHA      string     `json:"deadline_sha"`
+	DeadlineTaggedAt *time.Time `json:"deadline_tagged_at"`
+
+	// Rubric scores of the grade, empty when graded with a single score
+	CriterionScores []CriterionScore `json:"criterion_scores,omitempty"`
+}
+
+// FeedbackPRURL links to the Feedback pull request, or to the pull request
+// list while its number is unknown.
+func (s *Submission) FeedbackPRURL() string {
+	if s.RepoURL == "" {
+		return ""
+	}
+	if s.FeedbackPRNumber == 0 {
+		return s.RepoURL + "/pulls"
+	}
+	return fmt.Sprintf("%s/pulls/%d", s.RepoURL, s.FeedbackPRNumber)
 }
 
 // Review request statuses
@@ -102,6 +180,37 @@ const (
 	ReviewStatusSubmitted = "submitted"
 	ReviewStatusReviewed  = "reviewed"
 	ReviewStatusCancelled = "cancelled"
+
+	// Review outcomes: reviewed is a neutral review (comment, manual mark),
+	// the other two carry the verdict of the instructor
+	ReviewStatusChangesRequested = "changes_requested"
+	ReviewStatusAccepted         = "accepted"
+)
+
+// ReviewOutcomes are the statuses that finish a review
+var ReviewOutcomes = []string{ReviewStatusReviewed, ReviewStatusChangesRequested, ReviewStatusAccepted}
+
+// IsReviewOutcome reports whether status finishes a review
+func IsReviewOutcome(status string) bool {
+	for _, outcome := range ReviewOutcomes {
+		if status == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// Submission statuses
+const (
+	SubmissionStatusInProgress = "in_progress"
+	SubmissionStatusAccepted   = "accepted"
+	SubmissionStatusGraded     = "graded"
+)
+
+// Repository lock states of a review request
+const (
+	LockStateLocked   = "locked"
+	LockStateUnlocked = "unlocked"
 )
 
 type ReviewRequest struct {
@@ -118,6 +227,296 @@ type ReviewRequest struct {
 	SubmittedAt *time.Time `json:"submitted_at"`
 	ReviewedAt  *time.Time `json:"reviewed_at"`
 	SheetRowID  int        `json:"sheet_row_id"`
+
+	// CancelDeadline is the end of the window in which a pending request can
+	// still be cancelled; once it passes the review worker submits it.
+	CancelDeadline *time.Time `gorm:"index" json:"cancel_deadline"`
+
+	ReviewerID *uint      `gorm:"index" json:"reviewer_id"`
+	Reviewer   *User      `json:"reviewer,omitempty"`
+	AssignedAt *time.Time `json:"assigned_at"`
+
+	// Repository lock applied for this request. LockStrategy is remembered so
+	// the request is unlocked the way it was locked.
+	LockStrategy  string     `json:"lock_strategy"`
+	LockState     string     `json:"lock_state"`
+	LockUpdatedAt *time.Time `json:"lock_updated_at"`
+
+	// SLANotifiedAt is set once instructors were told the request is overdue
+	SLANotifiedAt *time.Time `json:"sla_notified_at"`
+}
+
+// CancelTimeRemaining returns how long a pending request can still be cancelled.
+func (r *ReviewRequest) CancelTimeRemaining(now time.Time) time.Duration {
+	if r.Status != ReviewStatusPending || r.CancelDeadline == nil {
+		return 0
+	}
+	remaining := r.CancelDeadline.Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// CanCancel reports whether a pending request is still inside its cancel window.
+func (r *ReviewRequest) CanCancel(now time.Time) bool {
+	return r.CancelTimeRemaining(now) > 0
+}
+
+// Review history event types
+const (
+	ReviewEventRequested = "requested"
+	ReviewEventCancelled = "cancelled"
+	ReviewEventSubmitted = "submitted"
+	ReviewEventReviewed  = "reviewed"
+)
+
+// Gitea review verdicts
+const (
+	ReviewVerdictApproved       = "APPROVED"
+	ReviewVerdictRequestChanges = "REQUEST_CHANGES"
+	ReviewVerdictComment        = "COMMENT"
+)
+
+// ReviewEvent is one entry of a submission's review history, written for
+// every status change of a review request
+type ReviewEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	SubmissionID    uint `gorm:"index" json:"submission_id"`
+	ReviewRequestID uint `gorm:"index" json:"review_request_id"`
+
+	Type   string `json:"type"`
+	Actor  string `json:"actor"`
+	Reason string `json:"reason,omitempty"`
+
+	// Status change of the review request, From is empty on creation
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+
+	// Set for reviewed events coming from a Gitea review
+	Verdict       string `json:"verdict,omitempty"`
+	Body          string `gorm:"type:text" json:"body,omitempty"`
+	GiteaReviewID int64  `json:"gitea_review_id,omitempty"`
+}
+
+// ReviewSinkEntry stores the reference a sink returned for a review request
+type ReviewSinkEntry struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ReviewRequestID uint   `gorm:"index" json:"review_request_id"`
+	Sink            string `json:"sink"`
+	Ref             string `json:"ref"`
+}
+
+// Commit is a commit pushed to a submission repository
+type Commit struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	SubmissionID uint   `gorm:"uniqueIndex:idx_commit_submission_sha" json:"submission_id"`
+	SHA          string `gorm:"uniqueIndex:idx_commit_submission_sha" json:"sha"`
+
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email"`
+	Pusher      string    `json:"pusher"`
+	Timestamp   time.Time `json:"timestamp"`
+	Branch      string    `json:"branch"` // branch the commit was first pushed to
+	Message     string    `gorm:"type:text" json:"message"`
+	URL         string    `json:"url"`
+
+	// Time the push was received, the commit timestamp is set by the author
+	PushedAt time.Time `gorm:"index" json:"pushed_at"`
+}
+
+// Rubric is the grading scheme of an assignment.
+type Rubric struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	AssignmentID uint              `gorm:"uniqueIndex" json:"assignment_id"`
+	Criteria     []RubricCriterion `json:"criteria"`
+}
+
+// RubricCriterion is one graded aspect of a submission. Descriptors tell
+// what a number of points stands for.
+type RubricCriterion struct {
+	ID       uint `gorm:"primarykey" json:"id"`
+	RubricID uint `gorm:"index" json:"rubric_id"`
+
+	Position    int                `json:"position"`
+	Title       string             `json:"title"`
+	Description string             `gorm:"type:text" json:"description"`
+	MaxPoints   int                `json:"max_points"`
+	Descriptors []RubricDescriptor `gorm:"foreignKey:CriterionID" json:"descriptors"`
+}
+
+// RubricDescriptor describes the work earning Points on a criterion.
+type RubricDescriptor struct {
+	ID          uint `gorm:"primarykey" json:"id"`
+	CriterionID uint `gorm:"index" json:"criterion_id"`
+
+	Points      int    `json:"points"`
+	Description string `gorm:"type:text" json:"description"`
+}
+
+// CriterionScore is the score of a submission on a rubric criterion.
+type CriterionScore struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	SubmissionID uint `gorm:"uniqueIndex:idx_score_submission_criterion" json:"submission_id"`
+	CriterionID  uint `gorm:"uniqueIndex:idx_score_submission_criterion" json:"criterion_id"`
+
+	Points     int    `json:"points"`
+	Comment    string `gorm:"type:text" json:"comment"`
+	GradedByID *uint  `json:"graded_by_id,omitempty"`
+}
+
+// Commit check states, as reported by Gitea commit statuses
+const (
+	CheckStatePending = "pending"
+	CheckStateSuccess = "success"
+	CheckStateWarning = "warning"
+	CheckStateFailure = "failure"
+	CheckStateError   = "error"
+)
+
+// CommitCheck is the latest result of one CI context, such as a workflow
+// job or an external status, on a submission commit.
+type CommitCheck struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	SubmissionID uint   `gorm:"uniqueIndex:idx_check_submission_sha_context" json:"submission_id"`
+	SHA          string `gorm:"uniqueIndex:idx_check_submission_sha_context" json:"sha"`
+	Context      string `gorm:"uniqueIndex:idx_check_submission_sha_context" json:"context"`
+
+	Source      string `json:"source"` // status or workflow_run
+	State       string `json:"state"`
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url"`
+
+	// Time Gitea reported the state, older reports never replace newer ones
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// WebhookDelivery is a Gitea webhook as it was received, with the outcome of
+// handling it
+type WebhookDelivery struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Event      string `gorm:"index" json:"event"`       // X-Gitea-Event
+	DeliveryID string `gorm:"index" json:"delivery_id"` // X-Gitea-Delivery
+	Repo       string `gorm:"index" json:"repo"`        // repository full name
+	Body       string `gorm:"type:text" json:"body,omitempty"`
+
+	// Time Gitea delivered the webhook, kept by replays
+	ReceivedAt time.Time `json:"received_at"`
+
+	Status     string `gorm:"index" json:"status"`
+	HTTPStatus int    `json:"http_status"`
+	Response   string `gorm:"type:text" json:"response,omitempty"`
+	Error      string `gorm:"type:text" json:"error,omitempty"`
+
+	// DedupeKey is claimed by the delivery that handles an action, later
+	// deliveries of the same action are recorded as DuplicateOf it
+	DedupeKey   *string `gorm:"uniqueIndex" json:"dedupe_key,omitempty"`
+	DuplicateOf *uint   `gorm:"index" json:"duplicate_of,omitempty"`
+
+	// Set on deliveries replayed by an admin
+	ReplayOf *uint `gorm:"index" json:"replay_of,omitempty"`
+}
+
+// Grading run statuses
+const (
+	GradingStatusQueued  = "queued"
+	GradingStatusRunning = "running"
+	GradingStatusPassed  = "passed"
+	GradingStatusFailed  = "failed"
+	GradingStatusTimeout = "timeout"
+	GradingStatusError   = "error"
+)
+
+// Grading run triggers
+const (
+	GradingTriggerManual   = "manual"
+	GradingTriggerPush     = "push"
+	GradingTriggerDeadline = "deadline"
+)
+
+// GradingRun is one autograding run of a submission at a commit.
+type GradingRun struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	SubmissionID uint       `gorm:"index" json:"submission_id"`
+	Submission   Submission `json:"-"`
+
+	Trigger     string `json:"trigger"`
+	RequestedBy *uint  `json:"requested_by,omitempty"`
+	Ref         string `json:"ref"` // ref asked for, SHA is the commit it resolved to
+	SHA         string `json:"sha"`
+	TestsSHA    string `json:"tests_sha,omitempty"`
+
+	Status     string     `gorm:"index" json:"status"`
+	ExitCode   *int       `json:"exit_code"`
+	Points     *int       `json:"points"`
+	Log        string     `gorm:"type:text" json:"log"`
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	Tests []GradingTestResult `gorm:"foreignKey:RunID" json:"tests,omitempty"`
+}
+
+// GradingTestResult is the outcome of one test of a grading run.
+type GradingTestResult struct {
+	ID    uint `gorm:"primarykey" json:"id"`
+	RunID uint `gorm:"index" json:"run_id"`
+
+	Name      string `json:"name"`
+	Passed    bool   `json:"passed"`
+	Points    int    `json:"points"`
+	MaxPoints int    `json:"max_points"`
+	Feedback  string `gorm:"type:text" json:"feedback,omitempty"`
+	Output    string `gorm:"type:text" json:"output,omitempty"`
+}
+
+// Job states
+const (
+	JobStateQueued  = "queued"
+	JobStateRunning = "running"
+	JobStateDone    = "done"
+	JobStateDead    = "dead"
+)
+
+// Job is a unit of work in the background job queue. Failed jobs are queued
+// again with a backoff until MaxAttempts, then they are left dead.
+type Job struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Kind    string `gorm:"index" json:"kind"`
+	Payload string `gorm:"type:text" json:"payload"`
+
+	State       string     `gorm:"index;default:'queued'" json:"state"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	RunAt       time.Time  `gorm:"index" json:"run_at"`
+	LockedAt    *time.Time `json:"locked_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	LastError   string     `gorm:"type:text" json:"last_error,omitempty"`
 }
 
 type StudentInvite struct {
@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// ReviewEvent is a review status update of a submission
+type ReviewEvent struct {
+	SubmissionID    uint       `json:"submission_id"`
+	ReviewRequestID uint       `json:"review_request_id"`
+	FromStatus      string     `json:"from_status,omitempty"`
+	Status          string     `json:"status"`
+	CancelDeadline  *time.Time `json:"cancel_deadline,omitempty"`
+	Actor           string     `json:"actor,omitempty"`
+	At              time.Time  `json:"at"`
+}
+
+// subscriberBuffer is how many events a slow subscriber may lag behind
+// before further events are dropped for it.
+const subscriberBuffer = 16
+
+// Broker fans review events out to in-process subscribers of a submission.
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[uint]map[chan ReviewEvent]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[uint]map[chan ReviewEvent]struct{})}
+}
+
+// Subscribe returns a channel receiving the submission's events and a
+// function that must be called to unsubscribe.
+func (b *Broker) Subscribe(submissionID uint) (<-chan ReviewEvent, func()) {
+	ch := make(chan ReviewEvent, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subs[submissionID] == nil {
+		b.subs[submissionID] = make(map[chan ReviewEvent]struct{})
+	}
+	b.subs[submissionID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs[submissionID], ch)
+			if len(b.subs[submissionID]) == 0 {
+				delete(b.subs, submissionID)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers the event to current subscribers without blocking.
+func (b *Broker) Publish(event ReviewEvent) {
+	if b == nil {
+		return
+	}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs[event.SubmissionID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package review
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+)
+
+// Reviewer assignment strategies
+const (
+	AssignNone         = "none"
+	AssignRoundRobin   = "round_robin"
+	AssignLoadBalanced = "load_balanced"
+)
+
+// IsAssignmentStrategy reports whether s is a known assignment strategy.
+func IsAssignmentStrategy(s string) bool {
+	switch s {
+	case AssignNone, AssignRoundRobin, AssignLoadBalanced:
+		return true
+	}
+	return false
+}
+
+// Assigner picks reviewers among a course's instructors and requests their
+// review on the Feedback PR.
+type Assigner struct {
+	cfg *config.Config
+}
+
+func NewAssigner(cfg *config.Config) *Assigner {
+	return &Assigner{cfg: cfg}
+}
+
+// AutoAssign assigns a reviewer to a submitted request according to the
+// course strategy. Requests that already have a reviewer are left alone.
+// The request must have Submission.Student and Submission.Assignment.Course
+// preloaded.
+func (a *Assigner) AutoAssign(rr *models.ReviewRequest) error {
+	if rr.ReviewerID != nil {
+		return nil
+	}
+
+	course := &rr.Submission.Assignment.Course
+	strategy := course.ReviewerAssignment
+	if strategy == "" {
+		strategy = a.cfg.ReviewerAssignment
+	}
+
+	var reviewer *models.User
+	var err error
+	switch strategy {
+	case AssignRoundRobin:
+		reviewer, err = nextRoundRobin(course.ID)
+	case AssignLoadBalanced:
+		reviewer, err = leastLoaded(course.ID)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if reviewer == nil {
+		return fmt.Errorf("course %s has no instructors to assign", course.Slug)
+	}
+
+	return a.Assign(rr, reviewer)
+}
+
+// Assign makes user the reviewer of the request and requests their review on
+// the Feedback PR. Failing to update Gitea is logged, the assignment is kept.
+func (a *Assigner) Assign(rr *models.ReviewRequest, user *models.User) error {
+	now := time.Now()
+	err := database.DB.Model(&models.ReviewRequest{}).Where("id = ?", rr.ID).
+		Updates(map[string]interface{}{
+			"reviewer_id": user.ID,
+			"assigned_at": now,
+		}).Error
+	if err != nil {
+		return err
+	}
+	rr.ReviewerID = &user.ID
+	rr.Reviewer = user
+	rr.AssignedAt = &now
+
+	log.Printf("Assigned reviewer %s to review request %d", user.Username, rr.ID)
+
+	if err := a.requestGiteaReview(&rr.Submission, user.Username); err != nil {
+		log.Printf("Warning: failed to request review from %s on Gitea: %v", user.Username, err)
+	}
+	return nil
+}
+
+func (a *Assigner) requestGiteaReview(submission *models.Submission, username string) error {
+	if a.cfg.GiteaAdminToken == "" {
+		return nil
+	}
+
+	giteaService, err := services.NewGiteaService(a.cfg.GiteaURL, a.cfg.GiteaAdminToken)
+	if err != nil {
+		return err
+	}
+
+	orgName := submission.Assignment.Course.OrgName
+	repoName := RepoNameFromURL(submission.RepoURL)
+
+	number, err := FeedbackPRNumber(giteaService, submission)
+	if err != nil {
+		return err
+	}
+
+	return giteaService.RequestReviewers(orgName, repoName, number, []string{username})
+}
+
+// FeedbackPRNumber returns the Feedback PR number, looking it up on Gitea
+// and storing it when it is not known yet.
+func FeedbackPRNumber(giteaService *services.GiteaService, submission *models.Submission) (int64, error) {
+	if submission.FeedbackPRNumber != 0 {
+		return submission.FeedbackPRNumber, nil
+	}
+
+	orgName := submission.Assignment.Course.OrgName
+	repoName := RepoNameFromURL(submission.RepoURL)
+
+	pr, err := giteaService.FindPullRequestByTitle(orgName, repoName, "Feedback")
+	if err != nil {
+		return 0, err
+	}
+	if pr == nil {
+		return 0, fmt.Errorf("no Feedback PR in %s/%s", orgName, repoName)
+	}
+
+	submission.FeedbackPRNumber = pr.Index
+	database.DB.Model(&models.Submission{}).Where("id = ?", submission.ID).Update("feedback_pr_number", pr.Index)
+	return pr.Index, nil
+}
+
+func courseInstructors(courseID uint) ([]models.User, error) {
+	var instructors []models.User
+	err := database.DB.
+		Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
+		Where("course_instructors.course_id = ?", courseID).
+		Order("users.id").
+		Find(&instructors).Error
+	return instructors, err
+}
+
+// instructorID returns the ID of the course instructor with the username,
+// 0 when no instructor of the course has it.
+func instructorID(courseID uint, username string) (uint, error) {
+	if username == "" {
+		return 0, nil
+	}
+	var ids []uint
+	err := database.DB.Model(&models.User{}).
+		Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
+		Where("course_instructors.course_id = ? AND users.username = ?", courseID, username).
+		Limit(1).Pluck("users.id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// nextRoundRobin returns the instructor following the one who was assigned
+// most recently in the course.
+func nextRoundRobin(courseID uint) (*models.User, error) {
+	instructors, err := courseInstructors(courseID)
+	if err != nil || len(instructors) == 0 {
+		return nil, err
+	}
+
+	var last models.ReviewRequest
+	err = database.DB.
+		Joins("JOIN submissions ON submissions.id = review_requests.submission_id").
+		Joins("JOIN assignments ON assignments.id = submissions.assignment_id").
+		Where("assignments.course_id = ? AND review_requests.reviewer_id IS NOT NULL", courseID).
+		Order("review_requests.assigned_at DESC").
+		First(&last).Error
+	if err != nil {
+		return &instructors[0], nil
+	}
+
+	for i, instructor := range instructors {
+		if instructor.ID > *last.ReviewerID {
+			return &instructors[i], nil
+		}
+	}
+	return &instructors[0], nil
+}
+
+// leastLoaded returns the instructor with the fewest submitted requests of
+// the course waiting for them, ties go to the lowest user id.
+func leastLoaded(courseID uint) (*models.User, error) {
+	instructors, err := courseInstructors(courseID)
+	if err != nil || len(instructors) == 0 {
+		return nil, err
+	}
+
+	var loads []struct {
+		ReviewerID uint
+		Count      int64
+	}
+	err = database.DB.Model(&models.ReviewRequest{}).
+		Select("review_requests.reviewer_id, COUNT(*) AS count").
+		Joins("JOIN submissions ON submissions.id = review_requests.submission_id").
+		Joins("JOIN assignments ON assignments.id = submissions.assignment_id").
+		Where("assignments.course_id = ? AND review_requests.reviewer_id IS NOT NULL AND review_requests.status = ?",
+			courseID, models.ReviewStatusSubmitted).
+		Group("review_requests.reviewer_id").
+		Scan(&loads).Error
+	if err != nil {
+		return nil, err
+	}
+
+	load := make(map[uint]int64, len(loads))
+	for _, l := range loads {
+		load[l.ReviewerID] = l.Count
+	}
+
+	best := &instructors[0]
+	for i := range instructors {
+		if load[instructors[i].ID] < load[best.ID] {
+			best = &instructors[i]
+		}
+	}
+	return best, nil
+}
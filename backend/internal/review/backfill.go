@@ -0,0 +1,89 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+)
+
+// JobKindBackfillIDs is the job recording Gitea IDs on older submissions.
+const JobKindBackfillIDs = "backfill_submission_ids"
+
+// MissingIDs counts the submissions without a Gitea repository ID or
+// Feedback PR number.
+func MissingIDs() (int64, error) {
+	var count int64
+	err := database.DB.Model(&models.Submission{}).
+		Where("repo_url <> '' AND (gitea_repo_id = 0 OR feedback_pr_number = 0)").
+		Count(&count).Error
+	return count, err
+}
+
+// BackfillIDs records the Gitea repository ID and Feedback PR number of
+// submissions created before they were stored. It fails when a submission
+// could not be looked up, so the job is retried.
+func BackfillIDs(cfg *config.Config) error {
+	if cfg.GiteaAdminToken == "" {
+		return errors.New("Gitea admin token not configured")
+	}
+	giteaService, err := services.NewGiteaService(cfg.GiteaURL, cfg.GiteaAdminToken)
+	if err != nil {
+		return err
+	}
+
+	var submissions []models.Submission
+	err = database.DB.Preload("Assignment.Course").
+		Where("repo_url <> '' AND (gitea_repo_id = 0 OR feedback_pr_number = 0)").
+		Find(&submissions).Error
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i := range submissions {
+		if err := backfillSubmission(giteaService, &submissions[i]); err != nil {
+			log.Printf("Failed to backfill Gitea IDs of submission %d: %v", submissions[i].ID, err)
+			failed++
+		}
+	}
+
+	log.Printf("Backfilled Gitea IDs of %d submissions, %d failed", len(submissions)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d submissions could not be backfilled", failed, len(submissions))
+	}
+	return nil
+}
+
+func backfillSubmission(giteaService *services.GiteaService, submission *models.Submission) error {
+	orgName := submission.Assignment.Course.OrgName
+	repoName := RepoNameFromURL(submission.RepoURL)
+
+	updates := map[string]interface{}{}
+	if submission.GiteaRepoID == 0 {
+		repo, err := giteaService.GetRepository(orgName, repoName)
+		if err != nil {
+			return err
+		}
+		updates["gitea_repo_id"] = repo.ID
+	}
+	if submission.FeedbackPRNumber == 0 {
+		// Repositories set up before the PR number was recorded
+		pr, err := giteaService.FindPullRequestByTitle(orgName, repoName, "Feedback")
+		if err != nil {
+			return err
+		}
+		if pr != nil {
+			updates["feedback_pr_number"] = pr.Index
+		}
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	return database.DB.Model(&models.Submission{}).Where("id = ?", submission.ID).Updates(updates).Error
+}
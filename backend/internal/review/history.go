@@ -0,0 +1,42 @@
+package review
+
+import (
+	"log"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// ActorSystem is recorded for changes made by background workers.
+const ActorSystem = "system"
+
+// NewEvent builds a history event for the request.
+func NewEvent(rr *models.ReviewRequest, eventType, actor string) models.ReviewEvent {
+	return models.ReviewEvent{
+		SubmissionID:    rr.SubmissionID,
+		ReviewRequestID: rr.ID,
+		Type:            eventType,
+		Actor:           actor,
+	}
+}
+
+// RecordEvent appends an event to the submission's review history. Failures
+// are only logged, the history never blocks a review.
+func RecordEvent(event models.ReviewEvent) {
+	if err := database.DB.Create(&event).Error; err != nil {
+		log.Printf("Warning: failed to record %s event for review request %d: %v", event.Type, event.ReviewRequestID, err)
+	}
+}
+
+// NormalizeVerdict maps Gitea review states and event names to a verdict.
+func NormalizeVerdict(state string) string {
+	switch state {
+	case "APPROVED", "approved", "pull_request_approved", "pull_request_review_approved":
+		return models.ReviewVerdictApproved
+	case "REQUEST_CHANGES", "request_changes", "rejected", "pull_request_rejected", "pull_request_review_rejected":
+		return models.ReviewVerdictRequestChanges
+	case "COMMENT", "comment", "pull_request_review_comment":
+		return models.ReviewVerdictComment
+	}
+	return ""
+}
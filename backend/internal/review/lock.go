@@ -0,0 +1,207 @@
+package review
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+)
+
+// Repository lock strategies
+const (
+	// LockPermission downgrades the student collaborator to read access
+	LockPermission = "permission"
+	// LockBranchProtection blocks pushes to main with a branch protection rule
+	LockBranchProtection = "branch_protection"
+)
+
+const lockedBranch = "main"
+
+// IsLockStrategy reports whether s is a known repository lock strategy.
+func IsLockStrategy(s string) bool {
+	switch s {
+	case LockPermission, LockBranchProtection:
+		return true
+	}
+	return false
+}
+
+// RepoLock makes a submission repository read-only for the student while a
+// review request is active and records the lock state on the request. All
+// methods expect Submission.Student and Submission.Assignment.Course to be
+// preloaded.
+type RepoLock struct {
+	cfg *config.Config
+}
+
+func NewRepoLock(cfg *config.Config) *RepoLock {
+	return &RepoLock{cfg: cfg}
+}
+
+// Strategy returns the strategy used for the request: the one it was locked
+// with, otherwise the course setting, otherwise the server default.
+func (l *RepoLock) Strategy(rr *models.ReviewRequest) string {
+	if rr.LockStrategy != "" {
+		return rr.LockStrategy
+	}
+	if s := rr.Submission.Assignment.Course.RepoLockStrategy; s != "" {
+		return s
+	}
+	if IsLockStrategy(l.cfg.RepoLockStrategy) {
+		return l.cfg.RepoLockStrategy
+	}
+	return LockPermission
+}
+
+// Lock blocks pushes from the student.
+func (l *RepoLock) Lock(rr *models.ReviewRequest) error {
+	strategy := l.Strategy(rr)
+
+	giteaService, err := l.giteaService(rr)
+	if err != nil {
+		return err
+	}
+
+	orgName, repoName := repoOf(rr)
+	switch strategy {
+	case LockBranchProtection:
+		exists, err := giteaService.HasBranchProtection(orgName, repoName, lockedBranch)
+		if err == nil && !exists {
+			err = giteaService.EnableBranchProtection(orgName, repoName, lockedBranch)
+		}
+		if err != nil {
+			l.record(rr, strategy, rr.LockState)
+			return err
+		}
+	default:
+		err := giteaService.AddCollaborator(orgName, repoName, rr.Submission.Student.Username, gitea.AccessModeRead)
+		if err != nil {
+			l.record(rr, strategy, rr.LockState)
+			return err
+		}
+	}
+
+	log.Printf("Locked %s/%s for review request %d (%s)", orgName, repoName, rr.ID, strategy)
+	return l.record(rr, strategy, models.LockStateLocked)
+}
+
+// Unlock gives the student write access back. Requests locked before the
+// strategy was recorded are unlocked both ways.
+func (l *RepoLock) Unlock(rr *models.ReviewRequest) error {
+	giteaService, err := l.giteaService(rr)
+	if err != nil {
+		return err
+	}
+
+	orgName, repoName := repoOf(rr)
+	strategy := rr.LockStrategy
+
+	if strategy == "" || strategy == LockBranchProtection {
+		exists, err := giteaService.HasBranchProtection(orgName, repoName, lockedBranch)
+		if err == nil && exists {
+			err = giteaService.DisableBranchProtection(orgName, repoName, lockedBranch)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if strategy == "" || strategy == LockPermission {
+		if err := giteaService.AddCollaborator(orgName, repoName, rr.Submission.Student.Username, gitea.AccessModeWrite); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Unlocked %s/%s for review request %d", orgName, repoName, rr.ID)
+	return l.record(rr, strategy, models.LockStateUnlocked)
+}
+
+// IsLocked reads the actual lock state from Gitea.
+func (l *RepoLock) IsLocked(rr *models.ReviewRequest) (bool, error) {
+	giteaService, err := l.giteaService(rr)
+	if err != nil {
+		return false, err
+	}
+
+	orgName, repoName := repoOf(rr)
+	switch l.Strategy(rr) {
+	case LockBranchProtection:
+		return giteaService.HasBranchProtection(orgName, repoName, lockedBranch)
+	default:
+		mode, err := giteaService.GetCollaboratorPermission(orgName, repoName, rr.Submission.Student.Username)
+		if err != nil {
+			return false, err
+		}
+		return mode == gitea.AccessModeRead || mode == gitea.AccessModeNone, nil
+	}
+}
+
+// Repair brings the repository in line with the request status: active
+// requests must be locked, finished ones unlocked. It reports whether Gitea
+// had to be changed.
+func (l *RepoLock) Repair(rr *models.ReviewRequest) (bool, error) {
+	wantLocked := rr.Status == models.ReviewStatusPending || rr.Status == models.ReviewStatusSubmitted
+
+	locked, err := l.IsLocked(rr)
+	if err != nil {
+		return false, err
+	}
+
+	if locked == wantLocked {
+		state := models.LockStateUnlocked
+		if locked {
+			state = models.LockStateLocked
+		}
+		if rr.LockState != state {
+			return false, l.record(rr, l.Strategy(rr), state)
+		}
+		return false, nil
+	}
+
+	if wantLocked {
+		return true, l.Lock(rr)
+	}
+	return true, l.Unlock(rr)
+}
+
+func (l *RepoLock) record(rr *models.ReviewRequest, strategy, state string) error {
+	now := time.Now()
+	err := database.DB.Model(&models.ReviewRequest{}).Where("id = ?", rr.ID).
+		Updates(map[string]interface{}{
+			"lock_strategy":   strategy,
+			"lock_state":      state,
+			"lock_updated_at": now,
+		}).Error
+	if err != nil {
+		return err
+	}
+	rr.LockStrategy = strategy
+	rr.LockState = state
+	rr.LockUpdatedAt = &now
+	return nil
+}
+
+// giteaService uses the admin token, falling back to a course instructor's
+// token when no admin token is configured.
+func (l *RepoLock) giteaService(rr *models.ReviewRequest) (*services.GiteaService, error) {
+	token := l.cfg.GiteaAdminToken
+	if token == "" {
+		instructors, err := courseInstructors(rr.Submission.Assignment.CourseID)
+		if err != nil {
+			return nil, err
+		}
+		if len(instructors) == 0 {
+			return nil, fmt.Errorf("no token to lock repositories of course %d", rr.Submission.Assignment.CourseID)
+		}
+		token = instructors[0].AccessToken
+	}
+	return services.NewGiteaService(l.cfg.GiteaURL, token)
+}
+
+func repoOf(rr *models.ReviewRequest) (string, string) {
+	return rr.Submission.Assignment.Course.OrgName, RepoNameFromURL(rr.Submission.RepoURL)
+}
@@ -0,0 +1,301 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/pubsub"
+	"github.com/Mond1c/gitea-classroom/internal/sinks"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidTransition is returned for status changes the review flow
+	// does not allow, e.g. cancelling a reviewed request.
+	ErrInvalidTransition = errors.New("invalid review status transition")
+	// ErrConflict is returned when the request changed concurrently, or left
+	// its cancel window, before the transition could be stored.
+	ErrConflict = errors.New("review request was changed concurrently")
+)
+
+// transitions lists the allowed status changes of a review request
+var transitions = map[string][]string{
+	models.ReviewStatusPending: {models.ReviewStatusSubmitted, models.ReviewStatusCancelled,
+		models.ReviewStatusReviewed, models.ReviewStatusChangesRequested, models.ReviewStatusAccepted},
+	models.ReviewStatusSubmitted: {models.ReviewStatusCancelled,
+		models.ReviewStatusReviewed, models.ReviewStatusChangesRequested, models.ReviewStatusAccepted},
+}
+
+// OutcomeStatus maps a review verdict to the status finishing the request.
+func OutcomeStatus(verdict string) string {
+	switch verdict {
+	case models.ReviewVerdictApproved:
+		return models.ReviewStatusAccepted
+	case models.ReviewVerdictRequestChanges:
+		return models.ReviewStatusChangesRequested
+	}
+	return models.ReviewStatusReviewed
+}
+
+// CanTransition reports whether a request may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition describes a status change and who made it.
+type Transition struct {
+	To     string
+	Actor  string
+	Reason string
+
+	// WithinCancelWindow only lets a pending request be cancelled while its
+	// cancel deadline has not passed.
+	WithinCancelWindow bool
+
+	// Review outcome, recorded with reviewed transitions
+	Verdict       string
+	Body          string
+	GiteaReviewID int64
+}
+
+// Machine is the only place that changes ReviewRequest.Status. Every change
+// is stored conditionally on the previous status, written to the review
+// history and followed by the same side effects on repository locks,
+// review sinks and reviewer assignment. Requests passed in must have
+// Submission.Student and Submission.Assignment.Course preloaded.
+type Machine struct {
+	cfg      *config.Config
+	sinks    *sinks.Registry
+	assigner *Assigner
+	locks    *RepoLock
+	events   *pubsub.Broker
+}
+
+func NewMachine(cfg *config.Config, reviewSinks *sinks.Registry, assigner *Assigner, locks *RepoLock, events *pubsub.Broker) *Machine {
+	return &Machine{
+		cfg:      cfg,
+		sinks:    reviewSinks,
+		assigner: assigner,
+		locks:    locks,
+		events:   events,
+	}
+}
+
+// Request creates a pending review request for the submission with a cancel
+// window of the configured length and locks the repository.
+func (m *Machine) Request(submission models.Submission, actor, reason string) (*models.ReviewRequest, error) {
+	now := time.Now()
+	deadline := now.Add(time.Duration(m.cfg.ReviewPendingMinutes) * time.Minute)
+	rr := &models.ReviewRequest{
+		SubmissionID:   submission.ID,
+		Status:         models.ReviewStatusPending,
+		RequestedAt:    now,
+		CancelDeadline: &deadline,
+	}
+	if err := database.DB.Create(rr).Error; err != nil {
+		return nil, err
+	}
+	rr.Submission = submission
+
+	event := NewEvent(rr, models.ReviewEventRequested, actor)
+	event.Reason = reason
+	event.ToStatus = models.ReviewStatusPending
+	RecordEvent(event)
+	m.publish(rr, "", actor)
+
+	// Block pushes while the review is active, the lock worker retries on failure
+	if err := m.locks.Lock(rr); err != nil {
+		log.Printf("Warning: failed to lock repository for review request %d: %v", rr.ID, err)
+	}
+
+	return rr, nil
+}
+
+// SubmitNow closes the cancel window of a pending request and submits it.
+// If the sinks fail the request is left for the review worker to retry.
+func (m *Machine) SubmitNow(rr *models.ReviewRequest, actor, reason string) error {
+	now := time.Now()
+	if err := database.DB.Model(&models.ReviewRequest{}).Where("id = ?", rr.ID).Update("cancel_deadline", now).Error; err != nil {
+		return err
+	}
+	rr.CancelDeadline = &now
+
+	return m.Apply(rr, Transition{
+		To:     models.ReviewStatusSubmitted,
+		Actor:  actor,
+		Reason: reason,
+	})
+}
+
+// Apply moves the request to t.To. On ErrInvalidTransition or ErrConflict
+// nothing was changed.
+func (m *Machine) Apply(rr *models.ReviewRequest, t Transition) error {
+	from := rr.Status
+	if !CanTransition(from, t.To) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, t.To)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": t.To}
+	if t.To == models.ReviewStatusSubmitted {
+		updates["submitted_at"] = now
+	}
+	// A review finished without a claim counts for the instructor finishing it
+	var reviewerID uint
+	if models.IsReviewOutcome(t.To) {
+		updates["reviewed_at"] = now
+		if rr.ReviewerID == nil {
+			id, err := instructorID(rr.Submission.Assignment.CourseID, t.Actor)
+			if err != nil {
+				log.Printf("Warning: failed to look up reviewer %s of review request %d: %v", t.Actor, rr.ID, err)
+			}
+			if id != 0 {
+				reviewerID = id
+				updates["reviewer_id"] = gorm.Expr("COALESCE(reviewer_id, ?)", id)
+			}
+		}
+	}
+
+	// Sinks see the request as it will be stored. A request that cannot be
+	// handed to its sinks stays pending and is retried by the worker.
+	if t.To == models.ReviewStatusSubmitted {
+		rr.Status = t.To
+		rr.SubmittedAt = &now
+		if err := m.sinks.Submit(rr); err != nil {
+			rr.Status = from
+			rr.SubmittedAt = nil
+			return err
+		}
+	}
+
+	query := database.DB.Model(&models.ReviewRequest{}).Where("id = ? AND status = ?", rr.ID, from)
+	if t.WithinCancelWindow && from == models.ReviewStatusPending {
+		query = query.Where("cancel_deadline > ?", now)
+	}
+	result := query.Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		if t.To == models.ReviewStatusSubmitted {
+			// Take back what the sinks were given for a request that did
+			// not end up submitted
+			m.sinks.Remove(rr)
+			rr.SubmittedAt = nil
+		}
+		rr.Status = from
+		if result.Error != nil {
+			return result.Error
+		}
+		return ErrConflict
+	}
+
+	rr.Status = t.To
+	if t.To == models.ReviewStatusSubmitted {
+		rr.SubmittedAt = &now
+	}
+	if models.IsReviewOutcome(t.To) {
+		rr.ReviewedAt = &now
+	}
+	if reviewerID != 0 {
+		rr.ReviewerID = &reviewerID
+	}
+
+	event := NewEvent(rr, eventType(t.To), t.Actor)
+	event.Reason = t.Reason
+	event.FromStatus = from
+	event.ToStatus = t.To
+	event.Verdict = t.Verdict
+	event.Body = t.Body
+	event.GiteaReviewID = t.GiteaReviewID
+	RecordEvent(event)
+
+	log.Printf("Review request %d: %s -> %s by %s", rr.ID, from, t.To, t.Actor)
+	m.publish(rr, from, t.Actor)
+
+	m.afterTransition(rr, from)
+	return nil
+}
+
+// afterTransition runs the side effects of entering rr.Status. They are
+// logged on failure, the lock worker repairs locks left behind.
+func (m *Machine) afterTransition(rr *models.ReviewRequest, from string) {
+	switch {
+	case rr.Status == models.ReviewStatusSubmitted:
+		if err := m.assigner.AutoAssign(rr); err != nil {
+			log.Printf("Warning: failed to assign reviewer to review request %d: %v", rr.ID, err)
+		}
+
+	case models.IsReviewOutcome(rr.Status):
+		if err := m.locks.Unlock(rr); err != nil {
+			log.Printf("Warning: failed to unlock repository for review request %d: %v", rr.ID, err)
+		}
+		m.sinks.MarkReviewed(rr)
+		if err := recordOutcome(rr); err != nil {
+			log.Printf("Warning: failed to update submission %d with review outcome: %v", rr.SubmissionID, err)
+		}
+
+	case rr.Status == models.ReviewStatusCancelled:
+		if err := m.locks.Unlock(rr); err != nil {
+			log.Printf("Warning: failed to unlock repository for review request %d: %v", rr.ID, err)
+		}
+		if from != models.ReviewStatusPending {
+			m.sinks.Remove(rr)
+		}
+	}
+}
+
+// recordOutcome stores the review outcome on the submission. An accepted
+// review moves the submission forward unless it is already graded.
+func recordOutcome(rr *models.ReviewRequest) error {
+	err := database.DB.Model(&models.Submission{}).Where("id = ?", rr.SubmissionID).
+		Update("review_outcome", rr.Status).Error
+	if err != nil {
+		return err
+	}
+	rr.Submission.ReviewOutcome = rr.Status
+
+	if rr.Status != models.ReviewStatusAccepted {
+		return nil
+	}
+	result := database.DB.Model(&models.Submission{}).
+		Where("id = ? AND status NOT IN ?", rr.SubmissionID,
+			[]string{models.SubmissionStatusAccepted, models.SubmissionStatusGraded}).
+		Update("status", models.SubmissionStatusAccepted)
+	if result.Error == nil && result.RowsAffected > 0 {
+		rr.Submission.Status = models.SubmissionStatusAccepted
+	}
+	return result.Error
+}
+
+// publish notifies live subscribers, e.g. the SSE stream, of the change.
+func (m *Machine) publish(rr *models.ReviewRequest, from, actor string) {
+	m.events.Publish(pubsub.ReviewEvent{
+		SubmissionID:    rr.SubmissionID,
+		ReviewRequestID: rr.ID,
+		FromStatus:      from,
+		Status:          rr.Status,
+		CancelDeadline:  rr.CancelDeadline,
+		Actor:           actor,
+	})
+}
+
+func eventType(status string) string {
+	switch status {
+	case models.ReviewStatusSubmitted:
+		return models.ReviewEventSubmitted
+	case models.ReviewStatusCancelled:
+		return models.ReviewEventCancelled
+	}
+	if models.IsReviewOutcome(status) {
+		return models.ReviewEventReviewed
+	}
+	return status
+}
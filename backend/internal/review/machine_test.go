@@ -0,0 +1,113 @@
+package review
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/pubsub"
+	"github.com/Mond1c/gitea-classroom/internal/sinks"
+)
+
+// connectTestDB connects to the Postgres database in TEST_DATABASE_URL and
+// skips the test without one.
+func connectTestDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	if err := database.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutcomeWithoutClaimRecordsReviewer(t *testing.T) {
+	connectTestDB(t)
+	db := database.DB
+	suffix := time.Now().UnixNano()
+
+	instructor := models.User{GiteaID: suffix, Username: fmt.Sprintf("instructor-%d", suffix)}
+	if err := db.Create(&instructor).Error; err != nil {
+		t.Fatal(err)
+	}
+	course := models.Course{
+		Name:        "Turnaround",
+		Slug:        fmt.Sprintf("turnaround-%d", suffix),
+		InviteCode:  fmt.Sprintf("invite-%d", suffix),
+		Instructors: []models.User{instructor},
+	}
+	if err := db.Create(&course).Error; err != nil {
+		t.Fatal(err)
+	}
+	assignment := models.Assignment{CourseID: course.ID, Title: "Lab", MaxPoints: 10}
+	if err := db.Create(&assignment).Error; err != nil {
+		t.Fatal(err)
+	}
+	student := models.Student{CourseID: course.ID, Username: fmt.Sprintf("student-%d", suffix)}
+	if err := db.Create(&student).Error; err != nil {
+		t.Fatal(err)
+	}
+	submission := models.Submission{AssignmentID: assignment.ID, StudentID: student.ID}
+	if err := db.Create(&submission).Error; err != nil {
+		t.Fatal(err)
+	}
+	submittedAt := time.Now().Add(-time.Hour)
+	rr := models.ReviewRequest{
+		SubmissionID: submission.ID,
+		Status:       models.ReviewStatusSubmitted,
+		RequestedAt:  submittedAt,
+		SubmittedAt:  &submittedAt,
+	}
+	if err := db.Create(&rr).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("review_request_id = ?", rr.ID).Delete(&models.ReviewEvent{})
+		db.Unscoped().Delete(&rr)
+		db.Unscoped().Delete(&submission)
+		db.Unscoped().Delete(&student)
+		db.Unscoped().Delete(&assignment)
+		db.Exec("DELETE FROM course_instructors WHERE course_id = ?", course.ID)
+		db.Unscoped().Delete(&course)
+		db.Unscoped().Delete(&instructor)
+	})
+
+	if err := db.Preload("Submission.Student").Preload("Submission.Assignment.Course").First(&rr, rr.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	machine := NewMachine(cfg, sinks.NewRegistry(nil), NewAssigner(cfg), NewRepoLock(cfg), pubsub.NewBroker())
+	err := machine.Apply(&rr, Transition{
+		To:     models.ReviewStatusReviewed,
+		Actor:  instructor.Username,
+		Reason: "/grade comment",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored models.ReviewRequest
+	if err := db.First(&stored, rr.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReviewerID == nil || *stored.ReviewerID != instructor.ID {
+		t.Fatalf("reviewer_id = %v, want %d", stored.ReviewerID, instructor.ID)
+	}
+
+	stats, err := CourseTurnaround(course.ID, time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Reviewed != 1 {
+		t.Fatalf("turnaround = %+v, want one review by %s", stats, instructor.Username)
+	}
+}
@@ -0,0 +1,135 @@
+package review
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// SLA returns how long a submitted review may wait in the course, zero when
+// the course has no SLA.
+func SLA(cfg *config.Config, course *models.Course) time.Duration {
+	days := course.ReviewSLADays
+	if days == 0 {
+		days = cfg.ReviewSLADays
+	}
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// IsOverdue reports whether the request has been waiting for review longer
+// than sla. Requests that are not submitted are never overdue.
+func IsOverdue(rr *models.ReviewRequest, sla time.Duration, now time.Time) bool {
+	if sla <= 0 || rr.Status != models.ReviewStatusSubmitted || rr.SubmittedAt == nil {
+		return false
+	}
+	return now.Sub(*rr.SubmittedAt) > sla
+}
+
+// OverdueRequests returns the course's submitted requests waiting longer
+// than sla, oldest first.
+func OverdueRequests(courseID uint, sla time.Duration, now time.Time) ([]models.ReviewRequest, error) {
+	var requests []models.ReviewRequest
+	if sla <= 0 {
+		return requests, nil
+	}
+
+	err := database.DB.
+		Joins("JOIN submissions ON submissions.id = review_requests.submission_id").
+		Joins("JOIN assignments ON assignments.id = submissions.assignment_id").
+		Where("assignments.course_id = ? AND review_requests.status = ? AND review_requests.submitted_at <= ?",
+			courseID, models.ReviewStatusSubmitted, now.Add(-sla)).
+		Preload("Submission.Student").
+		Preload("Submission.Assignment").
+		Preload("Reviewer").
+		Order("review_requests.submitted_at ASC").
+		Find(&requests).Error
+	return requests, err
+}
+
+// TurnaroundStats summarises how fast one instructor reviews.
+type TurnaroundStats struct {
+	ReviewerID uint   `json:"reviewer_id"`
+	Username   string `json:"username"`
+
+	Reviewed       int     `json:"reviewed"`
+	WithinSLA      int     `json:"within_sla"`
+	AverageHours   float64 `json:"average_hours"`
+	MedianHours    float64 `json:"median_hours"`
+	MaxHours       float64 `json:"max_hours"`
+	Waiting        int     `json:"waiting"`
+	WaitingOverdue int     `json:"waiting_overdue"`
+}
+
+// CourseTurnaround computes turnaround from SubmittedAt to ReviewedAt for
+// every instructor of the course, including the requests still waiting
+// for them.
+func CourseTurnaround(courseID uint, sla time.Duration, now time.Time) ([]TurnaroundStats, error) {
+	instructors, err := courseInstructors(courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []models.ReviewRequest
+	err = database.DB.
+		Joins("JOIN submissions ON submissions.id = review_requests.submission_id").
+		Joins("JOIN assignments ON assignments.id = submissions.assignment_id").
+		Where("assignments.course_id = ? AND review_requests.reviewer_id IS NOT NULL AND review_requests.status IN ?",
+			courseID, append([]string{models.ReviewStatusSubmitted}, models.ReviewOutcomes...)).
+		Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+
+	durations := make(map[uint][]time.Duration)
+	stats := make(map[uint]*TurnaroundStats)
+	for _, instructor := range instructors {
+		stats[instructor.ID] = &TurnaroundStats{ReviewerID: instructor.ID, Username: instructor.Username}
+	}
+
+	for i := range requests {
+		rr := &requests[i]
+		s, ok := stats[*rr.ReviewerID]
+		if !ok {
+			// Reviewer is no longer an instructor of the course
+			continue
+		}
+
+		switch {
+		case rr.Status == models.ReviewStatusSubmitted:
+			s.Waiting++
+			if IsOverdue(rr, sla, now) {
+				s.WaitingOverdue++
+			}
+		case rr.SubmittedAt != nil && rr.ReviewedAt != nil:
+			d := rr.ReviewedAt.Sub(*rr.SubmittedAt)
+			durations[*rr.ReviewerID] = append(durations[*rr.ReviewerID], d)
+			s.Reviewed++
+			if sla <= 0 || d <= sla {
+				s.WithinSLA++
+			}
+		}
+	}
+
+	result := make([]TurnaroundStats, 0, len(instructors))
+	for _, instructor := range instructors {
+		s := stats[instructor.ID]
+		if ds := durations[instructor.ID]; len(ds) > 0 {
+			sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+			var total time.Duration
+			for _, d := range ds {
+				total += d
+			}
+			s.AverageHours = (total / time.Duration(len(ds))).Hours()
+			s.MedianHours = ds[len(ds)/2].Hours()
+			s.MaxHours = ds[len(ds)-1].Hours()
+		}
+		result = append(result, *s)
+	}
+	return result, nil
+}
@@ -0,0 +1,13 @@
+package review
+
+import "strings"
+
+// RepoNameFromURL extracts the repository name from a URL like
+// "https://gitea.example.com/org/repo-name".
+func RepoNameFromURL(repoURL string) string {
+	parts := strings.Split(repoURL, "/")
+	if len(parts) > 0 {
+		return parts[len(parts)-1]
+	}
+	return ""
+}
@@ -2,6 +2,8 @@ package services
 
 import (
 	"fmt"
+	"io"
+	"net/http"
 
 	"code.gitea.io/sdk/gitea"
 )
@@ -181,13 +183,50 @@ func (s *GiteaService) CreatePullRequest(owner, repo, title, body, head, base st
 	return pr, nil
 }
 
-func (s *GiteaService) SetupFeedbackBranch(owner, repo string) error {
+// FindPullRequestByTitle returns the first pull request with the given
+// title, or nil if there is none.
+func (s *GiteaService) FindPullRequestByTitle(owner, repo, title string) (*gitea.PullRequest, error) {
+	prs, _, err := s.client.ListRepoPullRequests(owner, repo, gitea.ListPullRequestsOptions{
+		State: gitea.StateAll,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pr := range prs {
+		if pr.Title == title {
+			return pr, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *GiteaService) RequestReviewers(owner, repo string, index int64, reviewers []string) error {
+	opts := gitea.PullReviewRequestOptions{
+		Reviewers: reviewers,
+	}
+	_, err := s.client.CreateReviewRequests(owner, repo, index, opts)
+	return err
+}
+
+func (s *GiteaService) CreateIssueComment(owner, repo string, index int64, body string) (*gitea.Comment, error) {
+	opts := gitea.CreateIssueCommentOption{
+		Body: body,
+	}
+	comment, _, err := s.client.CreateIssueComment(owner, repo, index, opts)
+	return comment, err
+}
+
+// SetupFeedbackBranch creates the feedback branch and the Feedback pull
+// request instructors review on.
+func (s *GiteaService) SetupFeedbackBranch(owner, repo string) (*gitea.PullRequest, error) {
 	err := s.CreateBranch(owner, repo, "feedback", "main")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = s.CreatePullRequest(
+	return s.CreatePullRequest(
 		owner,
 		repo,
 		"Feedback",
@@ -195,7 +234,6 @@ func (s *GiteaService) SetupFeedbackBranch(owner, repo string) error {
 		"main",
 		"feedback",
 	)
-	return err
 }
 
 func (s *GiteaService) GetTeamByName(orgName, teamName string) (*gitea.Team, error) {
@@ -277,6 +315,26 @@ func (s *GiteaService) DisableBranchProtection(owner, repo, branch string) error
 	return err
 }
 
+// HasBranchProtection reports whether a protection rule exists for branch.
+func (s *GiteaService) HasBranchProtection(owner, repo, branch string) (bool, error) {
+	_, resp, err := s.client.GetBranchProtection(owner, repo, branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *GiteaService) GetCollaboratorPermission(owner, repo, username string) (gitea.AccessMode, error) {
+	result, _, err := s.client.CollaboratorPermission(owner, repo, username)
+	if err != nil {
+		return gitea.AccessModeNone, err
+	}
+	return result.Permission, nil
+}
+
 func (s *GiteaService) CreateRepoWebhook(owner, repo, webhookURL, secret string, events []string) (*gitea.Hook, error) {
 	opts := gitea.CreateHookOption{
 		Type: gitea.HookTypeGitea,
@@ -294,6 +352,66 @@ func (s *GiteaService) CreateRepoWebhook(owner, repo, webhookURL, secret string,
 	return hook, err
 }
 
+func (s *GiteaService) CreateOrgWebhook(orgName, webhookURL, secret string, events []string) (*gitea.Hook, error) {
+	opts := gitea.CreateHookOption{
+		Type: gitea.HookTypeGitea,
+		Config: map[string]string{
+			"url":          webhookURL,
+			"content_type": "json",
+			"secret":       secret,
+		},
+		Events:       events,
+		BranchFilter: "*",
+		Active:       true,
+	}
+
+	hook, _, err := s.client.CreateOrgHook(orgName, opts)
+	return hook, err
+}
+
+func (s *GiteaService) ListOrgWebhooks(orgName string) ([]*gitea.Hook, error) {
+	hooks, _, err := s.client.ListOrgHooks(orgName, gitea.ListHooksOptions{
+		ListOptions: gitea.ListOptions{PageSize: 50},
+	})
+	return hooks, err
+}
+
+func (s *GiteaService) ListRepoWebhooks(owner, repo string) ([]*gitea.Hook, error) {
+	hooks, _, err := s.client.ListRepoHooks(owner, repo, gitea.ListHooksOptions{
+		ListOptions: gitea.ListOptions{PageSize: 50},
+	})
+	return hooks, err
+}
+
+func (s *GiteaService) EditOrgWebhook(orgName string, id int64, webhookURL, secret string, events []string) error {
+	_, err := s.client.EditOrgHook(orgName, id, editHookOption(webhookURL, secret, events))
+	return err
+}
+
+func (s *GiteaService) EditRepoWebhook(owner, repo string, id int64, webhookURL, secret string, events []string) error {
+	_, err := s.client.EditRepoHook(owner, repo, id, editHookOption(webhookURL, secret, events))
+	return err
+}
+
+func (s *GiteaService) DeleteRepoWebhook(owner, repo string, id int64) error {
+	_, err := s.client.DeleteRepoHook(owner, repo, id)
+	return err
+}
+
+func editHookOption(webhookURL, secret string, events []string) gitea.EditHookOption {
+	active := true
+	return gitea.EditHookOption{
+		Config: map[string]string{
+			"url":          webhookURL,
+			"content_type": "json",
+			"secret":       secret,
+		},
+		Events:       events,
+		BranchFilter: "*",
+		Active:       &active,
+	}
+}
+
 func (s *GiteaService) IsTeamMember(orgName, teamName, username string) (bool, error) {
 	team, err := s.GetTeamByName(orgName, teamName)
 	if err != nil {
@@ -348,6 +466,95 @@ func (s *GiteaService) GetRepository(owner, repo string) (*gitea.Repository, err
 	return repository, err
 }
 
+// ResolveCommit returns the SHA of the commit a branch, tag or SHA points to.
+func (s *GiteaService) ResolveCommit(owner, repo, ref string) (string, error) {
+	commit, _, err := s.client.GetSingleCommit(owner, repo, ref)
+	if err != nil {
+		return "", err
+	}
+	if commit.CommitMeta == nil || commit.SHA == "" {
+		return "", fmt.Errorf("ref %s of %s/%s has no commit", ref, owner, repo)
+	}
+	return commit.SHA, nil
+}
+
+// CommitParent returns the SHA of the first parent of a commit, empty for a
+// root commit.
+func (s *GiteaService) CommitParent(owner, repo, sha string) (string, error) {
+	commit, _, err := s.client.GetSingleCommit(owner, repo, sha)
+	if err != nil {
+		return "", err
+	}
+	if len(commit.Parents) == 0 {
+		return "", nil
+	}
+	return commit.Parents[0].SHA, nil
+}
+
+// GetFile returns the content of a file at ref, nil when it does not exist.
+func (s *GiteaService) GetFile(owner, repo, ref, path string) ([]byte, error) {
+	data, resp, err := s.client.GetFile(owner, repo, ref, path)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+// GetArchive streams a tar.gz archive of the repository at ref. The caller
+// closes the reader.
+func (s *GiteaService) GetArchive(owner, repo, ref string) (io.ReadCloser, error) {
+	archive, _, err := s.client.GetArchiveReader(owner, repo, ref, gitea.TarGZArchive)
+	return archive, err
+}
+
+// SetTag points the tag at the commit, replacing a tag of the same name on
+// another commit.
+func (s *GiteaService) SetTag(owner, repo, name, sha, message string) error {
+	tag, resp, err := s.client.GetTag(owner, repo, name)
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusNotFound:
+	case err != nil:
+		return err
+	case tag.Commit != nil && tag.Commit.SHA == sha:
+		return nil
+	default:
+		if _, err := s.client.DeleteTag(owner, repo, name); err != nil {
+			return fmt.Errorf("failed to replace tag %s: %w", name, err)
+		}
+	}
+
+	_, _, err = s.client.CreateTag(owner, repo, gitea.CreateTagOption{
+		TagName: name,
+		Target:  sha,
+		Message: message,
+	})
+	return err
+}
+
+// ProtectTag keeps tags matching the name from being pushed, moved or
+// deleted by anyone but the account of the service token.
+func (s *GiteaService) ProtectTag(owner, repo, name string) error {
+	protections, _, err := s.client.ListTagProtection(owner, repo, gitea.ListRepoTagProtectionsOptions{})
+	if err != nil {
+		return err
+	}
+	for _, protection := range protections {
+		if protection.NamePattern == name {
+			return nil
+		}
+	}
+
+	user, err := s.GetUser()
+	if err != nil {
+		return err
+	}
+	_, _, err = s.client.CreateTagProtection(owner, repo, gitea.CreateTagProtectionOption{
+		NamePattern:        name,
+		WhitelistUsernames: []string{user.UserName},
+	})
+	return err
+}
+
 // Copy repository settings from template to new repo
 func (s *GiteaService) CopyRepoSettings(templateOwner, templateRepo, targetOwner, targetRepo string) error {
 	// Get template repository
@@ -0,0 +1,32 @@
+package sinks
+
+import (
+	"strconv"
+
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// DatabaseSink is the built-in queue. Review requests already live in the
+// review_requests table, so the sink only has to acknowledge them; selecting
+// it alone keeps a course entirely inside the LMS.
+type DatabaseSink struct{}
+
+func NewDatabaseSink() *DatabaseSink {
+	return &DatabaseSink{}
+}
+
+func (s *DatabaseSink) Name() string {
+	return SinkDatabase
+}
+
+func (s *DatabaseSink) Submit(req *models.ReviewRequest) (string, error) {
+	return strconv.FormatUint(uint64(req.ID), 10), nil
+}
+
+func (s *DatabaseSink) MarkReviewed(req *models.ReviewRequest, ref string) error {
+	return nil
+}
+
+func (s *DatabaseSink) Remove(req *models.ReviewRequest, ref string) error {
+	return nil
+}
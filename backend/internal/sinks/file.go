@@ -0,0 +1,87 @@
+package sinks
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// FileSink appends one line per review event to a local file. Files ending in
+// .csv are written as CSV, anything else as JSON Lines.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	csv  bool
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sink directory: %w", err)
+	}
+
+	return &FileSink{
+		path: path,
+		csv:  strings.EqualFold(filepath.Ext(path), ".csv"),
+	}, nil
+}
+
+func (s *FileSink) Name() string {
+	return SinkFile
+}
+
+func (s *FileSink) Submit(req *models.ReviewRequest) (string, error) {
+	return "", s.append(newRecord("submitted", req))
+}
+
+func (s *FileSink) MarkReviewed(req *models.ReviewRequest, ref string) error {
+	return s.append(newRecord("reviewed", req))
+}
+
+func (s *FileSink) Remove(req *models.ReviewRequest, ref string) error {
+	return s.append(newRecord("removed", req))
+}
+
+func (s *FileSink) append(rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open sink file: %w", err)
+	}
+	defer f.Close()
+
+	if !s.csv {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(line, '\n'))
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{
+		rec.Timestamp.Format(time.RFC3339),
+		rec.Event,
+		strconv.FormatUint(uint64(rec.ReviewRequestID), 10),
+		strconv.FormatUint(uint64(rec.SubmissionID), 10),
+		rec.Status,
+		rec.Course,
+		rec.Assignment,
+		rec.Student,
+		rec.FullName,
+		rec.RepoURL,
+		rec.RequestedAt.Format(time.RFC3339),
+	})
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,55 @@
+package sinks
+
+import (
+	"strconv"
+
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+)
+
+// SheetsSink appends review requests to the "ReviewRequests" Google Sheet.
+// The reference is the sheet row number.
+type SheetsSink struct {
+	sheets *services.SheetsService
+}
+
+func NewSheetsSink(sheets *services.SheetsService) *SheetsSink {
+	return &SheetsSink{sheets: sheets}
+}
+
+func (s *SheetsSink) Name() string {
+	return SinkSheets
+}
+
+func (s *SheetsSink) Submit(req *models.ReviewRequest) (string, error) {
+	rowID, err := s.sheets.AppendReviewRequest(studentName(req), req.Submission.RepoURL, req.RequestedAt)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(rowID), nil
+}
+
+func (s *SheetsSink) MarkReviewed(req *models.ReviewRequest, ref string) error {
+	row := sheetRow(req, ref)
+	if row <= 0 {
+		return nil
+	}
+	return s.sheets.UpdateRowStatus(row, "Проверено")
+}
+
+func (s *SheetsSink) Remove(req *models.ReviewRequest, ref string) error {
+	row := sheetRow(req, ref)
+	if row <= 0 {
+		return nil
+	}
+	return s.sheets.DeleteRow(row)
+}
+
+// sheetRow resolves the row of a request, requests submitted before sinks
+// existed only have it in ReviewRequest.SheetRowID.
+func sheetRow(req *models.ReviewRequest, ref string) int {
+	if row, err := strconv.Atoi(ref); err == nil {
+		return row
+	}
+	return req.SheetRowID
+}
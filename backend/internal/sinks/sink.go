@@ -0,0 +1,218 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// Sink names as they appear in course settings and REVIEW_SINKS
+const (
+	SinkSheets   = "sheets"
+	SinkDatabase = "database"
+	SinkFile     = "file"
+	SinkWebhook  = "webhook"
+)
+
+// ReviewSink receives review requests once they leave the cancel window and
+// is told when they are reviewed or withdrawn. The review request passed in
+// must have Submission.Student and Submission.Assignment.Course preloaded.
+type ReviewSink interface {
+	Name() string
+	// Submit records a new review request and returns a sink specific
+	// reference that is handed back on later calls.
+	Submit(req *models.ReviewRequest) (string, error)
+	MarkReviewed(req *models.ReviewRequest, ref string) error
+	Remove(req *models.ReviewRequest, ref string) error
+}
+
+// IsKnown reports whether name is one of the built-in sink names.
+func IsKnown(name string) bool {
+	switch name {
+	case SinkSheets, SinkDatabase, SinkFile, SinkWebhook:
+		return true
+	}
+	return false
+}
+
+// ParseNames splits a comma separated list of sink names.
+func ParseNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Registry holds the configured sinks and fans review updates out to the
+// sinks selected by each course.
+type Registry struct {
+	sinks    map[string]ReviewSink
+	defaults []string
+}
+
+func NewRegistry(defaults []string, sinks ...ReviewSink) *Registry {
+	r := &Registry{
+		sinks:    make(map[string]ReviewSink),
+		defaults: defaults,
+	}
+	for _, s := range sinks {
+		r.sinks[s.Name()] = s
+	}
+	return r
+}
+
+// ForCourse returns the configured sinks the course has selected, falling
+// back to the default list when the course has no explicit setting.
+func (r *Registry) ForCourse(course *models.Course) []ReviewSink {
+	names := ParseNames(course.ReviewSinks)
+	if len(names) == 0 {
+		names = r.defaults
+	}
+
+	var result []ReviewSink
+	for _, name := range names {
+		if s, ok := r.sinks[name]; ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Submit hands the request to every sink of its course. Sinks that already
+// accepted the request are skipped, so a failed submission can be retried
+// without creating duplicates.
+func (r *Registry) Submit(req *models.ReviewRequest) error {
+	refs, err := loadRefs(req.ID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, s := range r.ForCourse(&req.Submission.Assignment.Course) {
+		if _, done := refs[s.Name()]; done {
+			continue
+		}
+
+		ref, err := s.Submit(req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+			continue
+		}
+
+		entry := models.ReviewSinkEntry{
+			ReviewRequestID: req.ID,
+			Sink:            s.Name(),
+			Ref:             ref,
+		}
+		if err := database.DB.Create(&entry).Error; err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to store reference: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MarkReviewed notifies the sinks the request was submitted to that it was
+// reviewed. Failures are logged and returned, but do not stop other sinks.
+func (r *Registry) MarkReviewed(req *models.ReviewRequest) error {
+	return r.each(req, "mark reviewed", func(s ReviewSink, ref string) error {
+		return s.MarkReviewed(req, ref)
+	})
+}
+
+// Remove withdraws a submitted request from the sinks it was submitted to.
+func (r *Registry) Remove(req *models.ReviewRequest) error {
+	return r.each(req, "remove", func(s ReviewSink, ref string) error {
+		return s.Remove(req, ref)
+	})
+}
+
+func (r *Registry) each(req *models.ReviewRequest, action string, fn func(ReviewSink, string) error) error {
+	refs, err := loadRefs(req.ID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, s := range r.ForCourse(&req.Submission.Assignment.Course) {
+		ref, ok := refs[s.Name()]
+		// Requests submitted before sinks existed only reached the sheet
+		if !ok && s.Name() == SinkSheets && req.SheetRowID > 0 {
+			ref, ok = strconv.Itoa(req.SheetRowID), true
+		}
+		// Sinks never given the request have nothing to update
+		if !ok {
+			continue
+		}
+
+		if err := fn(s, ref); err != nil {
+			log.Printf("Warning: failed to %s review request %d in %s sink: %v", action, req.ID, s.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func loadRefs(reviewRequestID uint) (map[string]string, error) {
+	var entries []models.ReviewSinkEntry
+	if err := database.DB.Where("review_request_id = ?", reviewRequestID).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string, len(entries))
+	for _, e := range entries {
+		refs[e.Sink] = e.Ref
+	}
+	return refs, nil
+}
+
+// Record is the flat representation of a review request written by the file
+// and webhook sinks.
+type Record struct {
+	Event           string     `json:"event"`
+	Timestamp       time.Time  `json:"timestamp"`
+	ReviewRequestID uint       `json:"review_request_id"`
+	SubmissionID    uint       `json:"submission_id"`
+	Status          string     `json:"status"`
+	Course          string     `json:"course"`
+	Assignment      string     `json:"assignment"`
+	Student         string     `json:"student"`
+	FullName        string     `json:"full_name"`
+	RepoURL         string     `json:"repo_url"`
+	RequestedAt     time.Time  `json:"requested_at"`
+	SubmittedAt     *time.Time `json:"submitted_at,omitempty"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+}
+
+func newRecord(event string, req *models.ReviewRequest) Record {
+	return Record{
+		Event:           event,
+		Timestamp:       time.Now(),
+		ReviewRequestID: req.ID,
+		SubmissionID:    req.SubmissionID,
+		Status:          req.Status,
+		Course:          req.Submission.Assignment.Course.Slug,
+		Assignment:      req.Submission.Assignment.Title,
+		Student:         req.Submission.Student.Username,
+		FullName:        studentName(req),
+		RepoURL:         req.Submission.RepoURL,
+		RequestedAt:     req.RequestedAt,
+		SubmittedAt:     req.SubmittedAt,
+		ReviewedAt:      req.ReviewedAt,
+	}
+}
+
+func studentName(req *models.ReviewRequest) string {
+	if req.Submission.Student.FullName != "" {
+		return req.Submission.Student.FullName
+	}
+	return req.Submission.Student.Username
+}
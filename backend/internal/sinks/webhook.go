@@ -0,0 +1,76 @@
+package sinks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/models"
+)
+
+// WebhookSink posts every review event as JSON to an external URL. When a
+// secret is set the body is signed with HMAC-SHA256 in X-LMS-Signature.
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookSink(url, secret string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookSink) Name() string {
+	return SinkWebhook
+}
+
+func (s *WebhookSink) Submit(req *models.ReviewRequest) (string, error) {
+	return "", s.post(newRecord("submitted", req))
+}
+
+func (s *WebhookSink) MarkReviewed(req *models.ReviewRequest, ref string) error {
+	return s.post(newRecord("reviewed", req))
+}
+
+func (s *WebhookSink) Remove(req *models.ReviewRequest, ref string) error {
+	return s.post(newRecord("removed", req))
+}
+
+func (s *WebhookSink) post(rec Record) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-LMS-Event", rec.Event)
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		httpReq.Header.Set("X-LMS-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to deliver review webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("review webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
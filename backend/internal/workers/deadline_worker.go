@@ -0,0 +1,90 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/deadline"
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"gorm.io/gorm"
+)
+
+// DeadlineWorker handles assignments once their deadline passes, queueing
+// the snapshot of their submissions, which also starts the deadline grading.
+type DeadlineWorker struct {
+	queue    *jobs.Queue
+	db       *gorm.DB
+	interval time.Duration
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+func NewDeadlineWorker(queue *jobs.Queue, interval time.Duration) *DeadlineWorker {
+	return &DeadlineWorker{
+		queue:    queue,
+		db:       database.DB,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (w *DeadlineWorker) Start() {
+	w.ticker = time.NewTicker(w.interval)
+
+	go func() {
+		w.processDue()
+
+		for {
+			select {
+			case <-w.ticker.C:
+				w.processDue()
+			case <-w.stopChan:
+				w.ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	log.Println("Deadline worker started")
+}
+
+func (w *DeadlineWorker) Stop() {
+	close(w.stopChan)
+	log.Println("Deadline worker stopped")
+}
+
+func (w *DeadlineWorker) processDue() {
+	now := time.Now()
+
+	var assignments []models.Assignment
+	err := w.db.Where("deadline_processed_at IS NULL AND deadline > ? AND deadline <= ?", time.Time{}, now).
+		Find(&assignments).Error
+	if err != nil {
+		log.Printf("Failed to load assignments past their deadline: %v", err)
+		return
+	}
+
+	for i := range assignments {
+		assignment := &assignments[i]
+
+		// Claim the deadline, other servers skip it
+		result := w.db.Model(&models.Assignment{}).
+			Where("id = ? AND deadline_processed_at IS NULL", assignment.ID).
+			Update("deadline_processed_at", now)
+		if result.Error != nil {
+			log.Printf("Failed to claim deadline of assignment %d: %v", assignment.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := deadline.Enqueue(w.queue, assignment.ID); err != nil {
+			log.Printf("Failed to queue deadline snapshot of assignment %d: %v", assignment.ID, err)
+			// Release the claim, the next tick tries again
+			w.db.Model(&models.Assignment{}).Where("id = ?", assignment.ID).Update("deadline_processed_at", nil)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/hooks"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"gorm.io/gorm"
+)
+
+// HookWorker periodically verifies the webhooks of every course, so repos
+// whose hook went missing still trigger reviews.
+type HookWorker struct {
+	hooks    *hooks.Manager
+	db       *gorm.DB
+	interval time.Duration
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+func NewHookWorker(hookManager *hooks.Manager, interval time.Duration) *HookWorker {
+	return &HookWorker{
+		hooks:    hookManager,
+		db:       database.DB,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (w *HookWorker) Start() {
+	if !w.hooks.Enabled() {
+		log.Println("Hook worker disabled, no webhook URL or secret configured")
+		return
+	}
+
+	w.ticker = time.NewTicker(w.interval)
+
+	go func() {
+		w.reconcile()
+
+		for {
+			select {
+			case <-w.ticker.C:
+				w.reconcile()
+			case <-w.stopChan:
+				w.ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	log.Println("Hook worker started")
+}
+
+func (w *HookWorker) Stop() {
+	if w.ticker == nil {
+		return
+	}
+	close(w.stopChan)
+	log.Println("Hook worker stopped")
+}
+
+func (w *HookWorker) reconcile() {
+	var courses []models.Course
+	if err := w.db.Find(&courses).Error; err != nil {
+		log.Printf("Failed to load courses for webhook reconciliation: %v", err)
+		return
+	}
+
+	for i := range courses {
+		course := &courses[i]
+		report, err := w.hooks.ReconcileCourse(course)
+		if err != nil {
+			log.Printf("Failed to reconcile webhooks of course %s: %v", course.Slug, err)
+			continue
+		}
+		if report.Created+report.Updated+report.Removed > 0 || len(report.Failed) > 0 || report.OrgHook != hooks.ActionOK {
+			log.Printf("Reconciled webhooks of course %s: org hook %s, %d repos, %d created, %d updated, %d removed, %d failed",
+				course.Slug, report.OrgHook, report.Repos, report.Created, report.Updated, report.Removed, len(report.Failed))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/jobs"
+)
+
+// JobWorker runs jobs of a job queue pool on a fixed number of goroutines.
+// Workers wake on new jobs and poll for retries that became due.
+type JobWorker struct {
+	pool        *jobs.Pool
+	concurrency int
+	interval    time.Duration
+	ticker      *time.Ticker
+	stopChan    chan struct{}
+}
+
+func NewJobWorker(pool *jobs.Pool, concurrency int, interval time.Duration) *JobWorker {
+	return &JobWorker{
+		pool:        pool,
+		concurrency: concurrency,
+		interval:    interval,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+func (w *JobWorker) Start() {
+	w.ticker = time.NewTicker(w.interval)
+
+	for i := 0; i < w.concurrency; i++ {
+		go w.work()
+	}
+
+	go func() {
+		w.recoverStale()
+		w.pool.Notify()
+
+		for {
+			select {
+			case <-w.ticker.C:
+				w.recoverStale()
+				w.pool.Notify()
+			case <-w.stopChan:
+				w.ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	log.Printf("Job worker for %s jobs started with %d workers", w.pool, w.concurrency)
+}
+
+func (w *JobWorker) Stop() {
+	close(w.stopChan)
+	log.Printf("Job worker for %s jobs stopped", w.pool)
+}
+
+func (w *JobWorker) work() {
+	for {
+		select {
+		case <-w.pool.Wake():
+			w.drain()
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// drain runs due jobs until there are none left.
+func (w *JobWorker) drain() {
+	for {
+		select {
+		case <-w.stopChan:
+			return
+		default:
+		}
+
+		job, err := w.pool.Claim()
+		if err != nil {
+			log.Printf("Failed to claim job: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		// Let another worker pick up the next job meanwhile
+		w.pool.Notify()
+		w.pool.Run(job)
+	}
+}
+
+func (w *JobWorker) recoverStale() {
+	recovered, dead, err := w.pool.RecoverStale()
+	if err != nil {
+		log.Printf("Failed to recover stale jobs: %v", err)
+		return
+	}
+	if recovered > 0 {
+		log.Printf("Queued %d stale jobs again", recovered)
+	}
+	if dead > 0 {
+		log.Printf("%d stale jobs are dead, they were on their last attempt", dead)
+	}
+}
@@ -0,0 +1,96 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"gorm.io/gorm"
+)
+
+// LockWorker periodically checks that repositories with an active review
+// request are locked and that finished requests left no lock behind, as
+// read from Gitea rather than the recorded lock state.
+type LockWorker struct {
+	locks    *review.RepoLock
+	db       *gorm.DB
+	interval time.Duration
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+func NewLockWorker(locks *review.RepoLock, interval time.Duration) *LockWorker {
+	return &LockWorker{
+		locks:    locks,
+		db:       database.DB,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (w *LockWorker) Start() {
+	w.ticker = time.NewTicker(w.interval)
+
+	go func() {
+		w.repairLocks()
+
+		for {
+			select {
+			case <-w.ticker.C:
+				w.repairLocks()
+			case <-w.stopChan:
+				w.ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	log.Println("Lock worker started")
+}
+
+func (w *LockWorker) Stop() {
+	close(w.stopChan)
+	log.Println("Lock worker stopped")
+}
+
+func (w *LockWorker) repairLocks() {
+	active := []string{models.ReviewStatusPending, models.ReviewStatusSubmitted}
+
+	// Active requests, and unless another active request for the same
+	// submission holds the lock, finished requests recorded as locked and the
+	// latest request of each submission. Repair compares them with the actual
+	// state in Gitea, so a repository left read-only is found whatever the
+	// recorded state.
+	var requests []models.ReviewRequest
+	holder := w.db.Table("review_requests AS other").Select("1").
+		Where("other.submission_id = review_requests.submission_id AND other.status IN ? AND other.deleted_at IS NULL", active)
+	later := w.db.Table("review_requests AS other").Select("1").
+		Where("other.submission_id = review_requests.submission_id AND other.id > review_requests.id AND other.deleted_at IS NULL")
+	err := w.db.Preload("Submission.Student").Preload("Submission.Assignment.Course").
+		Where("status IN ? OR (NOT EXISTS (?) AND (lock_state = ? OR NOT EXISTS (?)))", active, holder, models.LockStateLocked, later).
+		Find(&requests).Error
+	if err != nil {
+		log.Printf("Failed to load review requests for lock repair: %v", err)
+		return
+	}
+
+	repaired := 0
+	for i := range requests {
+		rr := &requests[i]
+		changed, err := w.locks.Repair(rr)
+		if err != nil {
+			log.Printf("Failed to repair lock for review request %d: %v", rr.ID, err)
+			continue
+		}
+		if changed {
+			repaired++
+			log.Printf("Repaired lock drift for review request %d (status %s, lock %s)", rr.ID, rr.Status, rr.LockState)
+		}
+	}
+
+	if repaired > 0 {
+		log.Printf("Repaired %d repository locks", repaired)
+	}
+}
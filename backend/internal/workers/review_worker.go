@@ -1,30 +1,30 @@
 package workers
 
 import (
+	"errors"
 	"log"
 	"time"
 
-	"github.com/Mond1c/gitea-classroom/internal/cache"
 	"github.com/Mond1c/gitea-classroom/internal/database"
 	"github.com/Mond1c/gitea-classroom/internal/models"
-	"github.com/Mond1c/gitea-classroom/internal/services"
+	"github.com/Mond1c/gitea-classroom/internal/review"
 	"gorm.io/gorm"
 )
 
 type ReviewWorker struct {
-	cache    *cache.ReviewCache
-	sheets   *services.SheetsService
-	db       *gorm.DB
-	ticker   *time.Ticker
-	stopChan chan struct{}
+	reviews    *review.Machine
+	db         *gorm.DB
+	pendingTTL time.Duration
+	ticker     *time.Ticker
+	stopChan   chan struct{}
 }
 
-func NewReviewWorker(reviewCache *cache.ReviewCache, sheets *services.SheetsService) *ReviewWorker {
+func NewReviewWorker(reviews *review.Machine, pendingTTL time.Duration) *ReviewWorker {
 	return &ReviewWorker{
-		cache:    reviewCache,
-		sheets:   sheets,
-		db:       database.DB,
-		stopChan: make(chan struct{}),
+		reviews:    reviews,
+		db:         database.DB,
+		pendingTTL: pendingTTL,
+		stopChan:   make(chan struct{}),
 	}
 }
 
@@ -32,6 +32,11 @@ func (w *ReviewWorker) Start() {
 	w.ticker = time.NewTicker(30 * time.Second)
 
 	go func() {
+		// Pick up requests that were left pending by a previous run before
+		// waiting for the first tick.
+		w.recoverPendingReviews()
+		w.processExpiredReviews()
+
 		for {
 			select {
 			case <-w.ticker.C:
@@ -51,24 +56,52 @@ func (w *ReviewWorker) Stop() {
 	log.Println("Review worker stopped")
 }
 
+// recoverPendingReviews assigns a cancel deadline to pending requests created
+// before deadlines were stored, so they are picked up like any other request.
+func (w *ReviewWorker) recoverPendingReviews() {
+	var legacy []models.ReviewRequest
+	if err := w.db.Where("status = ? AND cancel_deadline IS NULL", models.ReviewStatusPending).Find(&legacy).Error; err != nil {
+		log.Printf("Failed to load pending review requests without deadline: %v", err)
+		return
+	}
+
+	for _, rr := range legacy {
+		deadline := rr.RequestedAt.Add(w.pendingTTL)
+		if err := w.db.Model(&models.ReviewRequest{}).Where("id = ?", rr.ID).Update("cancel_deadline", deadline).Error; err != nil {
+			log.Printf("Failed to set cancel deadline for review request %d: %v", rr.ID, err)
+		}
+	}
+
+	if len(legacy) > 0 {
+		log.Printf("Recovered %d pending review requests without cancel deadline", len(legacy))
+	}
+}
+
 func (w *ReviewWorker) processExpiredReviews() {
-	expired := w.cache.GetExpired()
+	var expired []models.ReviewRequest
+	err := w.db.Where("status = ? AND cancel_deadline <= ?", models.ReviewStatusPending, time.Now()).
+		Order("cancel_deadline").Find(&expired).Error
+	if err != nil {
+		log.Printf("Failed to load expired review requests: %v", err)
+		return
+	}
 
 	if len(expired) > 0 {
 		log.Printf("Found %d expired review requests", len(expired))
 	}
 
-	for _, pr := range expired {
-		log.Printf("Processing expired review request %d for submission %d", pr.ReviewRequestID, pr.SubmissionID)
-		if err := w.submitToSheets(pr.ReviewRequestID); err != nil {
-			log.Printf("Failed to submit review request %d to sheets: %v", pr.ReviewRequestID, err)
+	for _, rr := range expired {
+		log.Printf("Processing expired review request %d for submission %d", rr.ID, rr.SubmissionID)
+		if err := w.submitReview(rr.ID); err != nil {
+			// The request stays pending and is retried on the next tick
+			log.Printf("Failed to submit review request %d: %v", rr.ID, err)
 			continue
 		}
-		log.Printf("Successfully submitted review request %d to sheets", pr.ReviewRequestID)
+		log.Printf("Successfully submitted review request %d", rr.ID)
 	}
 }
 
-func (w *ReviewWorker) submitToSheets(reviewRequestID uint) error {
+func (w *ReviewWorker) submitReview(reviewRequestID uint) error {
 	var reviewRequest models.ReviewRequest
 	if err := w.db.Preload("Submission.Student").Preload("Submission.Assignment.Course").First(&reviewRequest, reviewRequestID).Error; err != nil {
 		return err
@@ -79,36 +112,15 @@ func (w *ReviewWorker) submitToSheets(reviewRequestID uint) error {
 		return nil
 	}
 
-	// Skip if sheets service is not configured
-	if w.sheets == nil {
-		log.Printf("Sheets service not configured, skipping sheet submission for review request %d", reviewRequestID)
-		// Still update status to submitted
-		now := time.Now()
-		reviewRequest.Status = models.ReviewStatusSubmitted
-		reviewRequest.SubmittedAt = &now
-		return w.db.Save(&reviewRequest).Error
-	}
-
-	// Add to Google Sheets
-	fullName := reviewRequest.Submission.Student.FullName
-	if fullName == "" {
-		fullName = reviewRequest.Submission.Student.Username
-	}
-
-	rowID, err := w.sheets.AppendReviewRequest(
-		fullName,
-		reviewRequest.Submission.RepoURL,
-		reviewRequest.RequestedAt,
-	)
-	if err != nil {
-		return err
+	// Only moves requests that are still pending, a student or instructor
+	// may have cancelled it in the meantime.
+	err := w.reviews.Apply(&reviewRequest, review.Transition{
+		To:     models.ReviewStatusSubmitted,
+		Actor:  review.ActorSystem,
+		Reason: "cancel window expired",
+	})
+	if errors.Is(err, review.ErrConflict) {
+		return nil
 	}
-
-	// Update review request
-	now := time.Now()
-	reviewRequest.Status = models.ReviewStatusSubmitted
-	reviewRequest.SubmittedAt = &now
-	reviewRequest.SheetRowID = rowID
-
-	return w.db.Save(&reviewRequest).Error
+	return err
 }
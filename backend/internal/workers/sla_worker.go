@@ -0,0 +1,140 @@
+package workers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Mond1c/gitea-classroom/config"
+	"github.com/Mond1c/gitea-classroom/internal/bot"
+	"github.com/Mond1c/gitea-classroom/internal/database"
+	"github.com/Mond1c/gitea-classroom/internal/models"
+	"github.com/Mond1c/gitea-classroom/internal/review"
+	"github.com/Mond1c/gitea-classroom/internal/services"
+	"gorm.io/gorm"
+)
+
+// SLAWorker notifies instructors on the Feedback PR once a submitted review
+// request has waited longer than the course review SLA.
+type SLAWorker struct {
+	cfg      *config.Config
+	bot      *bot.Bot
+	db       *gorm.DB
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+func NewSLAWorker(cfg *config.Config, replies *bot.Bot) *SLAWorker {
+	return &SLAWorker{
+		cfg:      cfg,
+		bot:      replies,
+		db:       database.DB,
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (w *SLAWorker) Start() {
+	w.ticker = time.NewTicker(15 * time.Minute)
+
+	go func() {
+		w.escalateOverdue()
+
+		for {
+			select {
+			case <-w.ticker.C:
+				w.escalateOverdue()
+			case <-w.stopChan:
+				w.ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	log.Println("SLA worker started")
+}
+
+func (w *SLAWorker) Stop() {
+	close(w.stopChan)
+	log.Println("SLA worker stopped")
+}
+
+func (w *SLAWorker) escalateOverdue() {
+	var requests []models.ReviewRequest
+	err := w.db.Preload("Submission.Student").Preload("Submission.Assignment.Course").Preload("Reviewer").
+		Where("status = ? AND sla_notified_at IS NULL", models.ReviewStatusSubmitted).
+		Order("submitted_at").Find(&requests).Error
+	if err != nil {
+		log.Printf("Failed to load submitted review requests: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range requests {
+		rr := &requests[i]
+		sla := review.SLA(w.cfg, &rr.Submission.Assignment.Course)
+		if !review.IsOverdue(rr, sla, now) {
+			continue
+		}
+
+		if err := w.notify(rr, sla, now); err != nil {
+			// Retried on the next tick
+			log.Printf("Failed to notify instructors about overdue review request %d: %v", rr.ID, err)
+			continue
+		}
+
+		if err := w.db.Model(&models.ReviewRequest{}).Where("id = ?", rr.ID).Update("sla_notified_at", now).Error; err != nil {
+			log.Printf("Failed to mark review request %d as notified: %v", rr.ID, err)
+		}
+	}
+}
+
+// notify mentions the assigned reviewer, or every course instructor when
+// nobody is assigned, on the Feedback PR.
+func (w *SLAWorker) notify(rr *models.ReviewRequest, sla time.Duration, now time.Time) error {
+	submission := &rr.Submission
+	course := &submission.Assignment.Course
+
+	var usernames []string
+	if rr.Reviewer != nil {
+		usernames = append(usernames, rr.Reviewer.Username)
+	} else {
+		err := w.db.Model(&models.User{}).
+			Joins("JOIN course_instructors ON course_instructors.user_id = users.id").
+			Where("course_instructors.course_id = ?", course.ID).
+			Pluck("users.username", &usernames).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Review request %d for %s is overdue (SLA %v), notifying %s",
+		rr.ID, submission.RepoURL, sla, strings.Join(usernames, ", "))
+
+	if w.cfg.GiteaAdminToken == "" {
+		return nil
+	}
+
+	giteaService, err := services.NewGiteaService(w.cfg.GiteaURL, w.cfg.GiteaAdminToken)
+	if err != nil {
+		return err
+	}
+
+	number, err := review.FeedbackPRNumber(giteaService, submission)
+	if err != nil {
+		return err
+	}
+
+	mentions := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		mentions = append(mentions, "@"+username)
+	}
+
+	data := map[string]interface{}{
+		"mentions":     strings.Join(mentions, " "),
+		"student":      submission.Student.Username,
+		"waiting_days": fmt.Sprintf("%.1f", now.Sub(*rr.SubmittedAt).Hours()/24),
+		"sla_days":     int(sla.Hours() / 24),
+	}
+	return w.bot.Post(course.OrgName, review.RepoNameFromURL(submission.RepoURL), number, "review_overdue", data)
+}
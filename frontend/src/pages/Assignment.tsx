@@ -57,6 +57,8 @@ const AssignmentPage: Component = () => {
     switch (status) {
       case 'graded':
         return 'bg-green-100 text-green-800';
+      case 'accepted':
+        return 'bg-green-100 text-green-800';
       case 'in_progress':
         return 'bg-yellow-100 text-yellow-800';
       case 'submitted':
@@ -66,6 +68,19 @@ const AssignmentPage: Component = () => {
     }
   };
 
+  const getCIColor = (state: string) => {
+    switch (state) {
+      case 'success':
+        return 'bg-green-100 text-green-800';
+      case 'failure':
+        return 'bg-red-100 text-red-800';
+      case 'pending':
+        return 'bg-yellow-100 text-yellow-800';
+      default:
+        return 'bg-gray-100 text-gray-800';
+    }
+  };
+
   return (
     <div class="container mx-auto px-4 py-8">
       <Show
@@ -184,6 +199,12 @@ const AssignmentPage: Component = () => {
                         <th class="px-4 py-3 text-left text-sm font-medium text-gray-500">
                           Status
                         </th>
+                        <th class="px-4 py-3 text-left text-sm font-medium text-gray-500">
+                          CI
+                        </th>
+                        <th class="px-4 py-3 text-left text-sm font-medium text-gray-500">
+                          Autograde
+                        </th>
                         <th class="px-4 py-3 text-left text-sm font-medium text-gray-500">
                           Score
                         </th>
@@ -213,6 +234,17 @@ const AssignmentPage: Component = () => {
                               >
                                 View Repository
                               </a>
+                              <Show when={submission.deadline_sha}>
+                                <a
+                                  href={`${submission.repo_url}/src/commit/${submission.deadline_sha}`}
+                                  target="_blank"
+                                  rel="noopener noreferrer"
+                                  class="block text-sm text-gray-500 hover:underline"
+                                  title={submission.deadline_sha}
+                                >
+                                  Deadline snapshot
+                                </a>
+                              </Show>
                             </td>
                             <td class="px-4 py-3">
                               <span
@@ -223,6 +255,25 @@ const AssignmentPage: Component = () => {
                                 {submission.status}
                               </span>
                             </td>
+                            <td class="px-4 py-3">
+                              <Show when={submission.ci_state} fallback="-">
+                                <span
+                                  class={`px-2 py-1 rounded-full text-xs font-medium ${getCIColor(
+                                    submission.ci_state
+                                  )}`}
+                                  title={submission.ci_sha}
+                                >
+                                  {submission.ci_state}
+                                </span>
+                              </Show>
+                            </td>
+                            <td class="px-4 py-3">
+                              <Show when={submission.autograde_status} fallback="-">
+                                <span title={submission.autograde_status}>
+                                  {submission.autograde_points ?? 0}/{assignment()?.max_points}
+                                </span>
+                              </Show>
+                            </td>
                             <td class="px-4 py-3">
                               {submission.score !== null
                                 ? `${submission.score}/${assignment()?.max_points}`
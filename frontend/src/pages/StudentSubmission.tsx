@@ -114,6 +114,10 @@ const StudentSubmission: Component = () => {
         return 'bg-blue-100 text-blue-800';
       case 'reviewed':
         return 'bg-green-100 text-green-800';
+      case 'accepted':
+        return 'bg-green-100 text-green-800';
+      case 'changes_requested':
+        return 'bg-orange-100 text-orange-800';
       case 'cancelled':
         return 'bg-gray-100 text-gray-800';
       default:
@@ -129,6 +133,10 @@ const StudentSubmission: Component = () => {
         return 'Waiting for Review';
       case 'reviewed':
         return 'Reviewed';
+      case 'accepted':
+        return 'Accepted';
+      case 'changes_requested':
+        return 'Changes Requested';
       case 'cancelled':
         return 'Cancelled';
       default:
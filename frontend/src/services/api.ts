@@ -50,10 +50,54 @@ export interface Assignment {
   deadline: string;
   max_points: number;
   academic_year: number;
+  grading_command: string;
+  grading_time_limit: number;
+  grading_memory_limit: number;
+  grade_on_push: boolean;
+  grade_at_deadline: boolean;
+  tests_repo: string;
+  tests_ref: string;
+  grading_result_format: string;
+  grading_result_path: string;
+  rubric?: Rubric;
   submissions?: Submission[];
   course?: Course;
 }
 
+export interface RubricDescriptor {
+  id: number;
+  criterion_id: number;
+  points: number;
+  description: string;
+}
+
+export interface RubricCriterion {
+  id: number;
+  rubric_id: number;
+  position: number;
+  title: string;
+  description: string;
+  max_points: number;
+  descriptors: RubricDescriptor[];
+}
+
+export interface Rubric {
+  id: number;
+  assignment_id: number;
+  criteria: RubricCriterion[];
+  created_at: string;
+  updated_at: string;
+}
+
+export interface CriterionScore {
+  id: number;
+  submission_id: number;
+  criterion_id: number;
+  points: number;
+  comment: string;
+  graded_by_id?: number;
+}
+
 export interface Student {
   id: number;
   course_id: number;
@@ -63,6 +107,47 @@ export interface Student {
   full_name: string;
 }
 
+export interface CommitCheck {
+  id: number;
+  submission_id: number;
+  sha: string;
+  context: string;
+  source: string;
+  state: string;
+  description: string;
+  target_url: string;
+  reported_at: string;
+}
+
+export interface GradingTestResult {
+  id: number;
+  run_id: number;
+  name: string;
+  passed: boolean;
+  points: number;
+  max_points: number;
+  feedback?: string;
+  output?: string;
+}
+
+export interface GradingRun {
+  id: number;
+  submission_id: number;
+  trigger: string;
+  ref: string;
+  sha: string;
+  tests_sha?: string;
+  status: string;
+  exit_code: number | null;
+  points: number | null;
+  log: string;
+  error?: string;
+  started_at: string | null;
+  finished_at: string | null;
+  created_at: string;
+  tests?: GradingTestResult[];
+}
+
 export interface Submission {
   id: number;
   assignment_id: number;
@@ -72,6 +157,19 @@ export interface Submission {
   score: number | null;
   feedback: string;
   submitted_at: string | null;
+  review_outcome: string;
+  last_push_at: string | null;
+  head_sha: string;
+  ci_state: string;
+  ci_sha: string;
+  checks?: CommitCheck[];
+  autograde_run_id: number | null;
+  autograde_status: string;
+  autograde_points: number | null;
+  autograded_at: string | null;
+  deadline_sha: string;
+  deadline_tagged_at: string | null;
+  criterion_scores?: CriterionScore[];
   student?: Student;
   assignment?: Assignment;
 }
@@ -123,6 +221,19 @@ export const assignmentAPI = {
     }>
   ) => api.put<Assignment>(`/assignments/${id}`, data),
   delete: (id: number) => api.delete(`/assignments/${id}`),
+  getRubric: (id: number) => api.get<Rubric>(`/assignments/${id}/rubric`),
+  updateRubric: (
+    id: number,
+    data: {
+      criteria: {
+        id?: number;
+        title: string;
+        description: string;
+        max_points: number;
+        descriptors: { points: number; description: string }[];
+      }[];
+    }
+  ) => api.put<Rubric>(`/assignments/${id}/rubric`, data),
 };
 
 export const studentAPI = {
@@ -140,8 +251,19 @@ export const submissionAPI = {
   list: (assignmentId: number) =>
     api.get<Submission[]>(`/assignments/${assignmentId}/submissions`),
   get: (id: number) => api.get<Submission>(`/submissions/${id}`),
-  grade: (id: number, data: { score: number; feedback: string }) =>
+  grade: (
+    id: number,
+    data: {
+      score: number;
+      feedback: string;
+      criteria?: { criterion_id: number; points: number; comment: string }[];
+    }
+  ) =>
     api.post<Submission>(`/submissions/${id}/grade`, data),
+  autograde: (id: number, ref = '') =>
+    api.post<GradingRun>(`/submissions/${id}/autograde`, { ref }),
+  gradingRuns: (id: number) =>
+    api.get<GradingRun[]>(`/submissions/${id}/grading-runs`),
 };
 
 export interface StudentInvite {